@@ -1,6 +1,17 @@
 package main
 
-import "fmt"
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
 
 // AbstractFactory interface
 type AbstractFactory interface {
@@ -27,45 +38,701 @@ func(factory ConcreteFactory2) CreateProductB() AbstractProductB {
 	return ConcreteProductB2{}
 }
 
+// Family registry
+// Families register under a name so the factory can be chosen at deploy time
+var families = map[string]func() AbstractFactory{}
+
+func RegisterFamily(name string, constructor func() AbstractFactory) {
+	if _, exists := families[name]; exists {
+		panic("abstract factory: family " + name + " registered twice")
+	}
+	families[name] = constructor
+}
+
+func RegisteredFamilies() []string {
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func GetFactory(name string) (AbstractFactory, error) {
+	constructor, ok := families[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown product family %q (registered families: %s)", name, strings.Join(RegisteredFamilies(), ", "))
+	}
+	return constructor(), nil
+}
+
+func init() {
+	RegisterFamily(Family1, func() AbstractFactory { return ConcreteFactory1{} })
+	RegisterFamily(Family2, func() AbstractFactory { return ConcreteFactory2{} })
+}
+
+// VerifyFamilies checks that every registered factory produces products of
+// a single family and that those products collaborate without complaint.
+// The registry name is not compared, so a family may be registered under
+// any number of deploy-time aliases
+func VerifyFamilies() error {
+	var problems []string
+	for _, name := range RegisteredFamilies() {
+		factory, _ := GetFactory(name)
+		productA := factory.CreateProductA()
+		productB := factory.CreateProductB()
+		if productA.Family() != productB.Family() {
+			problems = append(problems, fmt.Sprintf("%s: product A belongs to family %q but product B to %q", name, productA.Family(), productB.Family()))
+		}
+		if _, err := productB.AnotherUsefulFunctionB(productA); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New("inconsistent product families:\n  " + strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// Family selection
+// A flag wins over the environment variable, which wins over the config file
+const familyEnvVar = "ABSTRACT_FACTORY_FAMILY"
+
+type factoryConfig struct {
+	Family string `json:"family"`
+}
+
+func selectFamily(flagValue string, configPath string) (string, error) {
+	if flagValue != "" {
+		return flagValue, nil
+	}
+	if envValue := os.Getenv(familyEnvVar); envValue != "" {
+		return envValue, nil
+	}
+	if configPath == "" {
+		return "", nil
+	}
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return "", fmt.Errorf("reading config %s: %w", configPath, err)
+	}
+	var config factoryConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return "", fmt.Errorf("parsing config %s: %w", configPath, err)
+	}
+	return config.Family, nil
+}
+
+// Product families
+// Every product carries the name of the family it was made for
+const (
+	Family1 = "family1"
+	Family2 = "family2"
+)
+
+// StrictFamilies makes products refuse to collaborate across families
+// instead of only reporting the mismatch
+var StrictFamilies = false
+
+// FamilyMismatchError is returned when products of different families collaborate
+type FamilyMismatchError struct {
+	Product            string
+	Family             string
+	CollaboratorFamily string
+}
+
+func (e *FamilyMismatchError) Error() string {
+	return fmt.Sprintf("%s of family %q mixed with a product of family %q", e.Product, e.Family, e.CollaboratorFamily)
+}
+
+func checkFamily(product string, family string, collaborator AbstractProductA) error {
+	if collaborator.Family() == family {
+		return nil
+	}
+	return &FamilyMismatchError{Product: product, Family: family, CollaboratorFamily: collaborator.Family()}
+}
+
 // AbstractProductA interface
 type AbstractProductA interface {
+    Family() string
     UsefulFunctionA() string
 }
 
 // ConcreteProductA1 implements AbstractProductA 
 type ConcreteProductA1 struct{}
+func (product ConcreteProductA1) Family() string {
+    return Family1
+}
 func (product ConcreteProductA1) UsefulFunctionA() string {
     return "The result of the product A1."
 }
 
 // ConcreteProductA2 implements AbstractProductA
 type ConcreteProductA2 struct{}
+func (product ConcreteProductA2) Family() string {
+    return Family2
+}
 func (product ConcreteProductA2) UsefulFunctionA() string {
     return "The result of the product A2."
 }
 
 // AbstractProductB interface
+// AnotherUsefulFunctionB reports a *FamilyMismatchError when the collaborator
+// comes from another family; in strict mode it also refuses to do the work
 type AbstractProductB interface {
+    Family() string
     UsefulFunctionB() string
-    AnotherUsefulFunctionB(collaborator AbstractProductA) string
+    AnotherUsefulFunctionB(collaborator AbstractProductA) (string, error)
 }
 
 // ConcreteProductB1 implements AbstractProductB
 type ConcreteProductB1 struct{}
+func (product ConcreteProductB1) Family() string {
+    return Family1
+}
 func (product ConcreteProductB1) UsefulFunctionB() string {
     return "The result of the product B1."
 }
-func (product ConcreteProductB1) AnotherUsefulFunctionB(collaborator AbstractProductA) string {
-    return "The result of the B1 collaborating with the (" + collaborator.UsefulFunctionA() +")"
+func (product ConcreteProductB1) AnotherUsefulFunctionB(collaborator AbstractProductA) (string, error) {
+    err := checkFamily("B1", product.Family(), collaborator)
+    if err != nil && StrictFamilies {
+        return "", err
+    }
+    return "The result of the B1 collaborating with the (" + collaborator.UsefulFunctionA() +")", err
 }
 
 // ConcreteProductB2 implements AbstractProductB
 type ConcreteProductB2 struct{}
+func (product ConcreteProductB2) Family() string {
+    return Family2
+}
 func (product ConcreteProductB2) UsefulFunctionB() string {
     return "The result of the product B2."
 }
-func (product ConcreteProductB2) AnotherUsefulFunctionB(collaborator AbstractProductA) string {
-    return "The result of the B2 collaborating with the (" + collaborator.UsefulFunctionA() +")"
+func (product ConcreteProductB2) AnotherUsefulFunctionB(collaborator AbstractProductA) (string, error) {
+    err := checkFamily("B2", product.Family(), collaborator)
+    if err != nil && StrictFamilies {
+        return "", err
+    }
+    return "The result of the B2 collaborating with the (" + collaborator.UsefulFunctionA() +")", err
+}
+
+// Generic factory kit
+// A Kit is a family described as typed constructors keyed by product type,
+// so adding a product kind does not touch the existing families
+type Kit struct {
+	name         string
+	constructors map[reflect.Type]func() any
+}
+
+func NewKit(name string) *Kit {
+	return &Kit{name: name, constructors: map[reflect.Type]func() any{}}
+}
+
+// KindOf names a product kind by its (usually interface) type
+func KindOf[T any]() reflect.Type {
+	return reflect.TypeFor[T]()
+}
+
+// Provide sets the constructor for product kind T, replacing any earlier one
+func Provide[T any](kit *Kit, constructor func() T) *Kit {
+	kit.constructors[KindOf[T]()] = func() any { return constructor() }
+	return kit
+}
+
+// Make builds the product of kind T from the kit
+func Make[T any](kit *Kit) (T, error) {
+	var zero T
+	constructor, ok := kit.constructors[KindOf[T]()]
+	if !ok {
+		return zero, fmt.Errorf("kit %s has no constructor for %v", kit.name, KindOf[T]())
+	}
+	return constructor().(T), nil
+}
+
+func (kit *Kit) Name() string {
+	return kit.name
+}
+
+// Extend copies the kit under a new name so products can be overridden one by one
+func (kit *Kit) Extend(name string) *Kit {
+	return ComposeKits(name, kit)
+}
+
+// ComposeKits merges kits into a new one; later kits override earlier ones per product
+func ComposeKits(name string, kits ...*Kit) *Kit {
+	composed := NewKit(name)
+	for _, kit := range kits {
+		for kind, constructor := range kit.constructors {
+			composed.constructors[kind] = constructor
+		}
+	}
+	return composed
+}
+
+// Validate reports the required product kinds the kit cannot build
+func (kit *Kit) Validate(required ...reflect.Type) error {
+	var missing []string
+	for _, kind := range required {
+		if _, ok := kit.constructors[kind]; !ok {
+			missing = append(missing, kind.String())
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("kit %s is missing product kinds: %s", kit.name, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// KitFactory lets a kit stand in wherever an AbstractFactory is expected
+type KitFactory struct {
+	kit *Kit
+}
+
+func NewKitFactory(kit *Kit) (KitFactory, error) {
+	if err := kit.Validate(KindOf[AbstractProductA](), KindOf[AbstractProductB]()); err != nil {
+		return KitFactory{}, err
+	}
+	return KitFactory{kit: kit}, nil
+}
+func (factory KitFactory) CreateProductA() AbstractProductA {
+	product, _ := Make[AbstractProductA](factory.kit)
+	return product
+}
+func (factory KitFactory) CreateProductB() AbstractProductB {
+	product, _ := Make[AbstractProductB](factory.kit)
+	return product
+}
+
+// A third product kind that only the kits know about
+type AbstractProductC interface {
+	UsefulFunctionC() string
+}
+
+type ConcreteProductC1 struct{}
+func (product ConcreteProductC1) UsefulFunctionC() string {
+	return "The result of the product C1."
+}
+
+func family1Kit() *Kit {
+	kit := NewKit(Family1)
+	Provide(kit, func() AbstractProductA { return ConcreteProductA1{} })
+	Provide(kit, func() AbstractProductB { return ConcreteProductB1{} })
+	Provide(kit, func() AbstractProductC { return ConcreteProductC1{} })
+	return kit
+}
+
+func family2Kit() *Kit {
+	kit := NewKit(Family2)
+	Provide(kit, func() AbstractProductA { return ConcreteProductA2{} })
+	Provide(kit, func() AbstractProductB { return ConcreteProductB2{} })
+	return kit
+}
+
+// Dependency injection container
+// Constructors are plain functions whose parameters are resolved from the
+// container, e.g. func(factory AbstractFactory) AbstractProductA
+type Lifetime int
+
+const (
+	Transient Lifetime = iota
+	Singleton
+	Scoped
+)
+
+func (l Lifetime) String() string {
+	return [...]string{"transient", "singleton", "scoped"}[l]
+}
+
+var errorType = reflect.TypeFor[error]()
+
+type provider struct {
+	kind        reflect.Type
+	lifetime    Lifetime
+	constructor reflect.Value
+}
+
+// Container's mu only guards its maps; constructors and Close run without
+// it, so they may resolve from the container themselves
+type Container struct {
+	mu        sync.Mutex
+	providers map[reflect.Type]*provider
+	root      *Scope
+}
+
+// Scope caches scoped instances and closes what it created when disposed
+type Scope struct {
+	container *Container
+	instances map[reflect.Type]*instanceSlot
+	closers   []io.Closer
+	closed    bool
+}
+
+// instanceSlot holds a cached instance; done is closed once it is built, so
+// concurrent resolvers wait for the first constructor instead of running their own
+type instanceSlot struct {
+	done     chan struct{}
+	instance any
+	err      error
+}
+
+func NewContainer() *Container {
+	container := &Container{providers: map[reflect.Type]*provider{}}
+	container.root = container.NewScope()
+	return container
+}
+
+// Register adds a constructor returning T or (T, error); the last registration for T wins
+func (c *Container) Register(constructor any, lifetime Lifetime) error {
+	value := reflect.ValueOf(constructor)
+	if value.Kind() != reflect.Func || value.IsNil() {
+		return fmt.Errorf("container: constructor must be a non-nil function, got %T", constructor)
+	}
+	ctorType := value.Type()
+	if ctorType.NumOut() == 0 || ctorType.NumOut() > 2 || (ctorType.NumOut() == 2 && ctorType.Out(1) != errorType) {
+		return fmt.Errorf("container: constructor %v must return T or (T, error)", ctorType)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	kind := ctorType.Out(0)
+	c.providers[kind] = &provider{kind: kind, lifetime: lifetime, constructor: value}
+	return nil
+}
+
+// Root is the scope singletons live in
+func (c *Container) Root() *Scope {
+	return c.root
+}
+
+func (c *Container) NewScope() *Scope {
+	return &Scope{container: c, instances: map[reflect.Type]*instanceSlot{}}
+}
+
+// Close disposes the singletons
+func (c *Container) Close() error {
+	return c.root.Close()
+}
+
+// Resolve builds T and everything it depends on
+func Resolve[T any](scope *Scope) (T, error) {
+	var zero T
+	instance, err := scope.resolve(KindOf[T](), nil)
+	if err != nil {
+		return zero, err
+	}
+	typed, _ := instance.(T)
+	return typed, nil
+}
+
+func (s *Scope) resolve(kind reflect.Type, path []reflect.Type) (any, error) {
+	for i, seen := range path {
+		if seen == kind {
+			names := make([]string, 0, len(path)-i+1)
+			for _, step := range path[i:] {
+				names = append(names, step.String())
+			}
+			names = append(names, kind.String())
+			return nil, fmt.Errorf("container: dependency cycle %s", strings.Join(names, " -> "))
+		}
+	}
+	mu := &s.container.mu
+	mu.Lock()
+	p, ok := s.container.providers[kind]
+	if !ok {
+		mu.Unlock()
+		return nil, fmt.Errorf("container: no constructor registered for %v", kind)
+	}
+	owner := s
+	switch p.lifetime {
+	case Singleton:
+		owner = s.container.root
+	case Scoped:
+		if s == s.container.root {
+			mu.Unlock()
+			return nil, fmt.Errorf("container: scoped %v resolved outside a scope", kind)
+		}
+	}
+	if owner.closed {
+		mu.Unlock()
+		return nil, fmt.Errorf("container: resolving %v from a closed scope", kind)
+	}
+	var slot *instanceSlot
+	if p.lifetime != Transient {
+		if cached, ok := owner.instances[kind]; ok {
+			mu.Unlock()
+			<-cached.done
+			return cached.instance, cached.err
+		}
+		slot = &instanceSlot{done: make(chan struct{})}
+		owner.instances[kind] = slot
+	}
+	mu.Unlock()
+
+	instance, err := owner.construct(p, append(path, kind))
+
+	mu.Lock()
+	if err == nil && owner.closed {
+		err = fmt.Errorf("container: scope closed while constructing %v", kind)
+		if closer, ok := instance.(io.Closer); ok {
+			err = errors.Join(err, closer.Close())
+		}
+		instance = nil
+	}
+	if err == nil {
+		// only closers are kept, so transients resolved from Root are not pinned
+		if closer, ok := instance.(io.Closer); ok {
+			owner.closers = append(owner.closers, closer)
+		}
+	}
+	if slot != nil {
+		slot.instance, slot.err = instance, err
+		if err != nil {
+			// a failed construction is not cached, the next resolve retries
+			delete(owner.instances, kind)
+		}
+		close(slot.done)
+	}
+	mu.Unlock()
+	return instance, err
+}
+
+// construct resolves the constructor's parameters from the scope and calls it
+func (s *Scope) construct(p *provider, path []reflect.Type) (any, error) {
+	ctorType := p.constructor.Type()
+	args := make([]reflect.Value, ctorType.NumIn())
+	for i := range args {
+		dependency, err := s.resolve(ctorType.In(i), path)
+		if err != nil {
+			return nil, err
+		}
+		if dependency == nil {
+			args[i] = reflect.Zero(ctorType.In(i))
+		} else {
+			args[i] = reflect.ValueOf(dependency)
+		}
+	}
+	results := p.constructor.Call(args)
+	if len(results) == 2 && !results[1].IsNil() {
+		return nil, fmt.Errorf("container: constructing %v: %w", p.kind, results[1].Interface().(error))
+	}
+	return results[0].Interface(), nil
+}
+
+// Close disposes every io.Closer the scope created, newest first
+func (s *Scope) Close() error {
+	s.container.mu.Lock()
+	if s.closed {
+		s.container.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	closers := s.closers
+	s.closers = nil
+	s.instances = nil
+	s.container.mu.Unlock()
+
+	var errs []error
+	for i := len(closers) - 1; i >= 0; i-- {
+		errs = append(errs, closers[i].Close())
+	}
+	return errors.Join(errs...)
+}
+
+// requestLog is a scoped service that shows disposal at the end of a scope
+type requestLog struct {
+	id int
+}
+
+func (l *requestLog) Close() error {
+	fmt.Printf("Container: closing request log %d\n", l.id)
+	return nil
+}
+
+// Test doubles
+// RecordingFactory wraps any factory and logs what was created and how the
+// products collaborated; FakeFactory returns canned results
+type FactoryEvent struct {
+	Seq          int
+	Method       string
+	Product      string
+	Family       string
+	Collaborator string
+	// CollaboratorFamily is the family of the product passed to B, which
+	// need not have been made by the recorder
+	CollaboratorFamily string
+}
+
+func (e FactoryEvent) String() string {
+	if e.Collaborator != "" {
+		return fmt.Sprintf("%d %s %s(%s) with %s(%s)", e.Seq, e.Method, e.Product, e.Family, e.Collaborator, e.CollaboratorFamily)
+	}
+	return fmt.Sprintf("%d %s %s(%s)", e.Seq, e.Method, e.Product, e.Family)
+}
+
+type RecordingFactory struct {
+	inner  AbstractFactory
+	mu     sync.Mutex
+	events []FactoryEvent
+	counts map[string]int
+}
+
+func NewRecordingFactory(inner AbstractFactory) *RecordingFactory {
+	return &RecordingFactory{inner: inner, counts: map[string]int{}}
+}
+
+func (r *RecordingFactory) record(event FactoryEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	event.Seq = len(r.events) + 1
+	r.events = append(r.events, event)
+}
+
+func (r *RecordingFactory) nextID(kind string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.counts[kind]++
+	return fmt.Sprintf("%s#%d", kind, r.counts[kind])
+}
+
+func (r *RecordingFactory) Events() []FactoryEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]FactoryEvent(nil), r.events...)
+}
+
+func (r *RecordingFactory) CreateProductA() AbstractProductA {
+	product := &recordedProductA{AbstractProductA: r.inner.CreateProductA(), id: r.nextID("A")}
+	r.record(FactoryEvent{Method: "CreateProductA", Product: product.id, Family: product.Family()})
+	return product
+}
+func (r *RecordingFactory) CreateProductB() AbstractProductB {
+	product := &recordedProductB{AbstractProductB: r.inner.CreateProductB(), id: r.nextID("B"), recorder: r}
+	r.record(FactoryEvent{Method: "CreateProductB", Product: product.id, Family: product.Family()})
+	return product
+}
+
+type recordedProductA struct {
+	AbstractProductA
+	id string
+}
+
+type recordedProductB struct {
+	AbstractProductB
+	id       string
+	recorder *RecordingFactory
+}
+
+func (product *recordedProductB) AnotherUsefulFunctionB(collaborator AbstractProductA) (string, error) {
+	name := fmt.Sprintf("%T", collaborator)
+	if recorded, ok := collaborator.(*recordedProductA); ok {
+		name = recorded.id
+	}
+	product.recorder.record(FactoryEvent{
+		Method:             "AnotherUsefulFunctionB",
+		Product:            product.id,
+		Family:             product.Family(),
+		Collaborator:       name,
+		CollaboratorFamily: collaborator.Family(),
+	})
+	return product.AbstractProductB.AnotherUsefulFunctionB(collaborator)
+}
+
+type FakeFactory struct {
+	FamilyName     string
+	ResultA        string
+	ResultB        string
+	ResultAnotherB string
+	ErrAnotherB    error
+}
+
+func (f *FakeFactory) CreateProductA() AbstractProductA {
+	return fakeProductA{f}
+}
+func (f *FakeFactory) CreateProductB() AbstractProductB {
+	return fakeProductB{f}
+}
+
+type fakeProductA struct{ factory *FakeFactory }
+func (product fakeProductA) Family() string {
+	return product.factory.FamilyName
+}
+func (product fakeProductA) UsefulFunctionA() string {
+	return product.factory.ResultA
+}
+
+type fakeProductB struct{ factory *FakeFactory }
+func (product fakeProductB) Family() string {
+	return product.factory.FamilyName
+}
+func (product fakeProductB) UsefulFunctionB() string {
+	return product.factory.ResultB
+}
+func (product fakeProductB) AnotherUsefulFunctionB(collaborator AbstractProductA) (string, error) {
+	return product.factory.ResultAnotherB, product.factory.ErrAnotherB
+}
+
+// TestingT is the part of *testing.T the assertion helpers need
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...any)
+}
+
+// AssertCreationOrder checks the factory methods were called in the given order
+func AssertCreationOrder(t TestingT, r *RecordingFactory, methods ...string) bool {
+	t.Helper()
+	var got []string
+	for _, event := range r.Events() {
+		if strings.HasPrefix(event.Method, "Create") {
+			got = append(got, event.Method)
+		}
+	}
+	if strings.Join(got, ",") != strings.Join(methods, ",") {
+		t.Errorf("creation order = %v, want %v", got, methods)
+		return false
+	}
+	return true
+}
+
+// AssertCollaborated checks product worked with collaborator, e.g. "B#1" with "A#1"
+func AssertCollaborated(t TestingT, r *RecordingFactory, product string, collaborator string) bool {
+	t.Helper()
+	for _, event := range r.Events() {
+		if event.Product == product && event.Collaborator == collaborator {
+			return true
+		}
+	}
+	t.Errorf("%s never collaborated with %s; events: %v", product, collaborator, r.Events())
+	return false
+}
+
+// AssertSingleFamily checks every recorded product, and every product B
+// collaborated with, came from one family
+func AssertSingleFamily(t TestingT, r *RecordingFactory) bool {
+	t.Helper()
+	seen := map[string]bool{}
+	for _, event := range r.Events() {
+		seen[event.Family] = true
+		if event.Collaborator != "" {
+			seen[event.CollaboratorFamily] = true
+		}
+	}
+	if len(seen) > 1 {
+		t.Errorf("products came from %d families; events: %v", len(seen), r.Events())
+		return false
+	}
+	return true
+}
+
+// printingT reports assertion failures on stdout for the demo below
+type printingT struct{ failed bool }
+
+func (t *printingT) Helper() {}
+func (t *printingT) Errorf(format string, args ...any) {
+	t.failed = true
+	fmt.Printf("FAIL: "+format+"\n", args...)
 }
 
 // Client code
@@ -74,14 +741,136 @@ func ClientCode(factory AbstractFactory){
 	var productB = factory.CreateProductB()
 
 	fmt.Println(productB.UsefulFunctionB())
-	fmt.Println(productB.AnotherUsefulFunctionB(productA))
+	printCollaboration(productB, productA)
+}
+
+func printCollaboration(productB AbstractProductB, productA AbstractProductA) {
+	result, err := productB.AnotherUsefulFunctionB(productA)
+	if result != "" {
+		fmt.Println(result)
+	}
+	var mismatch *FamilyMismatchError
+	if errors.As(err, &mismatch) {
+		if StrictFamilies {
+			fmt.Println("Refused: " + mismatch.Error())
+		} else {
+			fmt.Println("Warning: " + mismatch.Error())
+		}
+	}
 }
 
 // Main function
 func main() {
-	fmt.Println("Client: Testing client code with the first factory type:")
-    ClientCode(ConcreteFactory1{})
+	familyFlag := flag.String("family", "", "product family to use ("+strings.Join(RegisteredFamilies(), ", ")+")")
+	configPath := flag.String("config", "factory.json", "JSON config file with a \"family\" field")
+	flag.BoolVar(&StrictFamilies, "strict", false, "refuse cross-family collaboration")
+	flag.Parse()
+
+	if err := VerifyFamilies(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	name, err := selectFamily(*familyFlag, *configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if name != "" {
+		factory, err := GetFactory(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println("Client: Testing client code with the " + name + " factory type:")
+		ClientCode(factory)
+		return
+	}
+
+	// No family selected, so exercise every registered one
+	for i, name := range RegisteredFamilies() {
+		if i > 0 {
+			fmt.Println("")
+		}
+		factory, _ := GetFactory(name)
+		fmt.Println("Client: Testing client code with the " + name + " factory type:")
+		ClientCode(factory)
+	}
+
+	fmt.Println("")
+	fmt.Println("Client: Mixing products from different families:")
+	printCollaboration(ConcreteFactory1{}.CreateProductB(), ConcreteFactory2{}.CreateProductA())
+
+	fmt.Println("")
+	fmt.Println("Client: Testing client code with a kit-based factory:")
+	kitFactory, err := NewKitFactory(family1Kit())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	ClientCode(kitFactory)
+	productC, _ := Make[AbstractProductC](family1Kit())
+	fmt.Println(productC.UsefulFunctionC())
+
+	fmt.Println("")
+	fmt.Println("Client: Checking kits for the product kinds they must provide:")
+	custom := family2Kit().Extend("family2-custom")
+	Provide(custom, func() AbstractProductC { return ConcreteProductC1{} })
+	allKinds := []reflect.Type{KindOf[AbstractProductA](), KindOf[AbstractProductB](), KindOf[AbstractProductC]()}
+	for _, kit := range []*Kit{family1Kit(), family2Kit(), custom} {
+		if err := kit.Validate(allKinds...); err != nil {
+			fmt.Println(err)
+		} else {
+			fmt.Println("kit " + kit.Name() + " provides every product kind")
+		}
+	}
+
+	fmt.Println("")
+	fmt.Println("Client: Testing client code with a factory from the container:")
+	container := NewContainer()
+	requests := 0
+	container.Register(func() (AbstractFactory, error) { return GetFactory(Family2) }, Singleton)
+	container.Register(func(factory AbstractFactory) AbstractProductA { return factory.CreateProductA() }, Transient)
+	container.Register(func(factory AbstractFactory) AbstractProductB { return factory.CreateProductB() }, Transient)
+	container.Register(func() *requestLog { requests++; return &requestLog{id: requests} }, Scoped)
+	for i := 0; i < 2; i++ {
+		scope := container.NewScope()
+		factory, err := Resolve[AbstractFactory](scope)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		ClientCode(factory)
+		first, _ := Resolve[*requestLog](scope)
+		second, _ := Resolve[*requestLog](scope)
+		fmt.Printf("Container: same request log within the scope: %v\n", first == second)
+		scope.Close()
+	}
+	container.Close()
+
+	fmt.Println("")
+	fmt.Println("Client: Resolving a dependency cycle:")
+	cyclic := NewContainer()
+	cyclic.Register(func(b AbstractProductB) AbstractProductA { return ConcreteProductA1{} }, Transient)
+	cyclic.Register(func(a AbstractProductA) AbstractProductB { return ConcreteProductB1{} }, Transient)
+	if _, err := Resolve[AbstractProductA](cyclic.NewScope()); err != nil {
+		fmt.Println(err)
+	}
+
+	fmt.Println("")
+	fmt.Println("Client: Recording what the client code asks of a factory:")
+	recorder := NewRecordingFactory(ConcreteFactory1{})
+	ClientCode(recorder)
+	for _, event := range recorder.Events() {
+		fmt.Println(event)
+	}
+	t := &printingT{}
+	AssertCreationOrder(t, recorder, "CreateProductA", "CreateProductB")
+	AssertCollaborated(t, recorder, "B#1", "A#1")
+	AssertSingleFamily(t, recorder)
+	fmt.Printf("Client: assertions passed: %v\n", !t.failed)
+
 	fmt.Println("")
-	fmt.Println("Client: Testing the same client code with the second factory type:")
-    ClientCode(ConcreteFactory2{})
+	fmt.Println("Client: Testing client code with a scripted fake factory:")
+	ClientCode(&FakeFactory{FamilyName: "fake", ResultB: "canned B", ResultAnotherB: "canned collaboration"})
 }
\ No newline at end of file
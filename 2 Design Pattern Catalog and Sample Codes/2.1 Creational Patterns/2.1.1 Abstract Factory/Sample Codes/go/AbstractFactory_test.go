@@ -0,0 +1,209 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mixedFactory hands out products of two different families
+type mixedFactory struct{}
+func(factory mixedFactory) CreateProductA() AbstractProductA {
+	return ConcreteProductA1{}
+}
+func(factory mixedFactory) CreateProductB() AbstractProductB {
+	return ConcreteProductB2{}
+}
+
+// registerForTest adds a family for the duration of one test
+func registerForTest(t *testing.T, name string, constructor func() AbstractFactory) {
+	t.Helper()
+	RegisterFamily(name, constructor)
+	t.Cleanup(func() { delete(families, name) })
+}
+
+func TestEveryFamilyIsConsistent(t *testing.T) {
+	for _, name := range RegisteredFamilies() {
+		t.Run(name, func(t *testing.T) {
+			factory, err := GetFactory(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			productA := factory.CreateProductA()
+			productB := factory.CreateProductB()
+			if productA.Family() != productB.Family() {
+				t.Errorf("product A belongs to %q, product B to %q", productA.Family(), productB.Family())
+			}
+			if _, err := productB.AnotherUsefulFunctionB(productA); err != nil {
+				t.Errorf("products of one factory do not collaborate: %v", err)
+			}
+		})
+	}
+	if err := VerifyFamilies(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestVerifyFamiliesAcceptsAliases(t *testing.T) {
+	registerForTest(t, "prod", func() AbstractFactory { return ConcreteFactory1{} })
+	if err := VerifyFamilies(); err != nil {
+		t.Errorf("an alias for family1 was rejected: %v", err)
+	}
+}
+
+func TestVerifyFamiliesRejectsMixedFactory(t *testing.T) {
+	registerForTest(t, "mixed", func() AbstractFactory { return mixedFactory{} })
+	err := VerifyFamilies()
+	if err == nil || !strings.Contains(err.Error(), "mixed:") {
+		t.Errorf("VerifyFamilies() = %v, want an error naming the mixed family", err)
+	}
+}
+
+func TestRootKeepsOnlyClosers(t *testing.T) {
+	container := NewContainer()
+	container.Register(func() AbstractProductA { return ConcreteProductA1{} }, Transient)
+	container.Register(func() *requestLog { return &requestLog{} }, Transient)
+	for i := 0; i < 100; i++ {
+		if _, err := Resolve[AbstractProductA](container.Root()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := Resolve[*requestLog](container.Root()); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(container.Root().closers); got != 1 {
+		t.Errorf("root tracks %d instances, want only the request log", got)
+	}
+}
+
+// silentT lets a test expect an assertion helper to fail
+type silentT struct{}
+
+func (silentT) Helper()               {}
+func (silentT) Errorf(string, ...any) {}
+
+func TestAssertSingleFamilyChecksCollaborators(t *testing.T) {
+	recorder := NewRecordingFactory(ConcreteFactory1{})
+	recorder.CreateProductB().AnotherUsefulFunctionB(ConcreteProductA2{})
+	if AssertSingleFamily(&silentT{}, recorder) {
+		t.Errorf("a B collaborating with a foreign family-2 A passed; events: %v", recorder.Events())
+	}
+
+	recorder = NewRecordingFactory(ConcreteFactory1{})
+	recorder.CreateProductB().AnotherUsefulFunctionB(recorder.CreateProductA())
+	AssertSingleFamily(t, recorder)
+}
+
+func TestContainerLifetimes(t *testing.T) {
+	container := NewContainer()
+	built := map[string]int{}
+	container.Register(func() AbstractFactory { built["singleton"]++; return ConcreteFactory1{} }, Singleton)
+	container.Register(func() *requestLog { built["scoped"]++; return &requestLog{} }, Scoped)
+	container.Register(func(factory AbstractFactory) AbstractProductA { built["transient"]++; return factory.CreateProductA() }, Transient)
+
+	first, second := container.NewScope(), container.NewScope()
+	for _, scope := range []*Scope{first, first, second} {
+		if _, err := Resolve[AbstractProductA](scope); err != nil {
+			t.Fatal(err)
+		}
+	}
+	logA, _ := Resolve[*requestLog](first)
+	logB, _ := Resolve[*requestLog](first)
+	logC, _ := Resolve[*requestLog](second)
+	if logA != logB || logA == logC {
+		t.Error("scoped instances are not one per scope")
+	}
+	want := map[string]int{"singleton": 1, "scoped": 2, "transient": 3}
+	for lifetime, n := range want {
+		if built[lifetime] != n {
+			t.Errorf("%s constructor ran %d times, want %d", lifetime, built[lifetime], n)
+		}
+	}
+	if _, err := Resolve[*requestLog](container.Root()); err == nil {
+		t.Error("resolving a scoped service from the root succeeded")
+	}
+}
+
+func TestContainerDetectsCycles(t *testing.T) {
+	container := NewContainer()
+	container.Register(func(b AbstractProductB) AbstractProductA { return ConcreteProductA1{} }, Singleton)
+	container.Register(func(a AbstractProductA) AbstractProductB { return ConcreteProductB1{} }, Transient)
+	_, err := Resolve[AbstractProductA](container.NewScope())
+	want := "container: dependency cycle main.AbstractProductA -> main.AbstractProductB -> main.AbstractProductA"
+	if err == nil || err.Error() != want {
+		t.Errorf("Resolve() error = %v, want %q", err, want)
+	}
+}
+
+type orderedCloser struct {
+	name   string
+	closed *[]string
+}
+
+func (c orderedCloser) Close() error {
+	*c.closed = append(*c.closed, c.name)
+	return nil
+}
+
+func TestScopeDisposal(t *testing.T) {
+	var closed []string
+	container := NewContainer()
+	container.Register(func() orderedCloser { return orderedCloser{"singleton", &closed} }, Singleton)
+	container.Register(func(orderedCloser) *requestLog { return &requestLog{} }, Scoped)
+	container.Register(func(orderedCloser) io.Closer { return orderedCloser{"transient", &closed} }, Transient)
+
+	scope := container.NewScope()
+	if _, err := Resolve[*requestLog](scope); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Resolve[io.Closer](scope); err != nil {
+		t.Fatal(err)
+	}
+	scope.Close()
+	if strings.Join(closed, ",") != "transient" {
+		t.Errorf("closing the scope closed %v, want only its transient", closed)
+	}
+	if _, err := Resolve[*requestLog](scope); err == nil {
+		t.Error("resolving from a closed scope succeeded")
+	}
+	container.Close()
+	if strings.Join(closed, ",") != "transient,singleton" {
+		t.Errorf("closed %v, want the singleton last", closed)
+	}
+}
+
+func TestConstructorsMayResolve(t *testing.T) {
+	container := NewContainer()
+	container.Register(func() AbstractFactory { return ConcreteFactory2{} }, Singleton)
+	container.Register(func() (AbstractProductA, error) {
+		factory, err := Resolve[AbstractFactory](container.Root())
+		if err != nil {
+			return nil, err
+		}
+		return factory.CreateProductA(), nil
+	}, Singleton)
+	done := make(chan error, 1)
+	go func() {
+		_, err := Resolve[AbstractProductA](container.Root())
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("a constructor resolving from the container deadlocked")
+	}
+}
+
+func TestRegisterRejectsNil(t *testing.T) {
+	container := NewContainer()
+	var constructor func() AbstractFactory
+	for _, bad := range []any{nil, constructor, 42} {
+		if err := container.Register(bad, Transient); err == nil {
+			t.Errorf("Register(%#v) succeeded", bad)
+		}
+	}
+}
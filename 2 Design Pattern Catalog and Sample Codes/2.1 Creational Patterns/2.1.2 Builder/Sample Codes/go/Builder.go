@@ -1,8 +1,16 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"os"
+	"sort"
 	"strings"
+	"sync"
 )
 
 // Product
@@ -18,39 +26,237 @@ type Builder interface{
 	ProducePartA()
 	ProducePartB()
 	ProducePartC()
-	GetProduct() Product1
+	GetProduct() (Product1, error)
 }
 func getBuilder() Builder{
 	return newConcreteBuilder1()
 }
 
+// Build rules
+// Required parts must be produced; Before lists the parts a step needs first
+type BuildRules struct{
+	Required []string
+	Before   map[string][]string
+}
+
+// RuleViolation describes a single broken rule
+type RuleViolation struct{
+	Rule string
+	Part string
+	Detail string
+}
+func (v RuleViolation) String() string{
+	return v.Rule + " " + v.Part + ": " + v.Detail
+}
+
+// ValidationError lists every rule the product broke
+type ValidationError struct{
+	Violations []RuleViolation
+}
+func (e *ValidationError) Error() string{
+	details := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		details[i] = v.String()
+	}
+	return "invalid product: " + strings.Join(details, "; ")
+}
+
 // Concrete Builder
 type ConcreteBuilder1 struct{ 
 	product Product1 
+	rules BuildRules
+	steps []string
+	violations []RuleViolation
 }
 func newConcreteBuilder1() *ConcreteBuilder1{
-	return &ConcreteBuilder1{}
+	return &ConcreteBuilder1{
+		rules: BuildRules{
+			Required: []string{"PartA"},
+			Before: map[string][]string{"PartC": {"PartA"}},
+		},
+	}
+}
+func (b *ConcreteBuilder1) step(name string, part string){
+	for _, needed := range b.rules.Before[name] {
+		if !b.produced(needed) {
+			b.violations = append(b.violations, RuleViolation{
+				Rule: "order", Part: name, Detail: "needs " + needed + " to be produced first",
+			})
+		}
+	}
+	b.steps = append(b.steps, name)
+	b.product.parts = append(b.product.parts, part)
+}
+func (b *ConcreteBuilder1) produced(name string) bool{
+	for _, step := range b.steps {
+		if step == name {
+			return true
+		}
+	}
+	return false
 }
 func (b *ConcreteBuilder1) ProducePartA(){
-	b.product.parts = append(b.product.parts, "PartA1")
+	b.step("PartA", "PartA1")
 }
 func (b *ConcreteBuilder1) ProducePartB(){
-	b.product.parts = append(b.product.parts, "PartB1")
+	b.step("PartB", "PartB1")
 }
 func (b *ConcreteBuilder1) ProducePartC(){
-	b.product.parts = append(b.product.parts, "PartC1")
+	b.step("PartC", "PartC1")
 }
-func (b *ConcreteBuilder1) GetProduct() Product1{
+func (b *ConcreteBuilder1) GetProduct() (Product1, error){
+	violations := b.violations
+	for _, required := range b.rules.Required {
+		if !b.produced(required) {
+			violations = append(violations, RuleViolation{
+				Rule: "required", Part: required, Detail: "was never produced",
+			})
+		}
+	}
 	result := Product1{
 		parts: b.product.parts,
 	}
 	b.product = Product1{}
-	return result
+	b.steps = nil
+	b.violations = nil
+	if len(violations) > 0 {
+		return Product1{}, &ValidationError{Violations: violations}
+	}
+	return result, nil
+}
+
+// RecipeError tells which Director recipe produced an invalid product
+type RecipeError struct{
+	Recipe string
+	Err error
+}
+func (e *RecipeError) Error() string{
+	return "recipe " + e.Recipe + ": " + e.Err.Error()
+}
+func (e *RecipeError) Unwrap() error{
+	return e.Err
+}
+
+// Recipes
+// A recipe lists build steps as data; optional steps only run when asked for
+// by part name, and repeat produces the part several times
+type RecipeStep struct{
+	Part string `json:"part"`
+	Optional bool `json:"optional,omitempty"`
+	Repeat int `json:"repeat,omitempty"`
+}
+type Recipe struct{
+	Name string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Steps []RecipeStep `json:"steps"`
+}
+type recipeFile struct{
+	Recipes []Recipe `json:"recipes"`
+}
+
+var buildSteps = map[string]func(Builder){
+	"PartA": Builder.ProducePartA,
+	"PartB": Builder.ProducePartB,
+	"PartC": Builder.ProducePartC,
+}
+
+const defaultRecipes = `{"recipes": [
+	{"name": "minimal", "description": "Standard basic product", "steps": [{"part": "PartA"}]},
+	{"name": "full", "description": "Standard full featured product",
+	 "steps": [{"part": "PartA"}, {"part": "PartB"}, {"part": "PartC"}]},
+	{"name": "bundle", "description": "Two A parts with an optional accessory",
+	 "steps": [{"part": "PartA", "repeat": 2}, {"part": "PartC", "optional": true}]}
+]}`
+
+// run checks every part and every requested option is known before
+// producing anything, so a bad call fails without leaving the builder half done
+func (r Recipe) run(b Builder, with []string) error{
+	for i, step := range r.Steps {
+		if _, ok := buildSteps[step.Part]; !ok {
+			return fmt.Errorf("step %d: unknown part %q", i+1, step.Part)
+		}
+	}
+	optional := r.optionalParts()
+	for _, part := range with {
+		if !contains(optional, part) {
+			return fmt.Errorf("%q is not an optional part (optional parts: %s)", part, strings.Join(optional, ", "))
+		}
+	}
+	for _, step := range r.Steps {
+		if step.Optional && !contains(with, step.Part) {
+			continue
+		}
+		for i := 0; i < max(step.Repeat, 1); i++ {
+			buildSteps[step.Part](b)
+		}
+	}
+	return nil
+}
+
+// optionalParts lists the distinct parts a caller may ask for with "with"
+func (r Recipe) optionalParts() []string{
+	var parts []string
+	for _, step := range r.Steps {
+		if step.Optional && !contains(parts, step.Part) {
+			parts = append(parts, step.Part)
+		}
+	}
+	return parts
+}
+
+// validate checks the recipe is well formed and, by dry-running it with every
+// combination of its optional parts, that it satisfies the builder's rules.
+// There are only as many optional parts as build steps, so at most 2^3 runs
+func (r Recipe) validate(newBuilder func() Builder) error{
+	if r.Name == "" {
+		return errors.New("recipe without a name")
+	}
+	if len(r.Steps) == 0 {
+		return fmt.Errorf("recipe %s has no steps", r.Name)
+	}
+	for i, step := range r.Steps {
+		if _, ok := buildSteps[step.Part]; !ok {
+			return fmt.Errorf("recipe %s step %d: unknown part %q", r.Name, i+1, step.Part)
+		}
+		if step.Repeat < 0 {
+			return fmt.Errorf("recipe %s step %d: negative repeat %d", r.Name, i+1, step.Repeat)
+		}
+	}
+	optional := r.optionalParts()
+	for mask := 0; mask < 1<<len(optional); mask++ {
+		var with []string
+		for i, part := range optional {
+			if mask&(1<<i) != 0 {
+				with = append(with, part)
+			}
+		}
+		builder := newBuilder()
+		if err := r.run(builder, with); err != nil {
+			return err
+		}
+		if _, err := builder.GetProduct(); err != nil {
+			if len(with) > 0 {
+				err = fmt.Errorf("with %s: %w", strings.Join(with, ","), err)
+			}
+			return &RecipeError{Recipe: r.Name, Err: err}
+		}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool{
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }
 
 // Director
 type Director struct{ 
 	builder Builder 
+	recipes map[string]Recipe
 }
 func newDirector(b Builder) *Director{
 	return &Director{
@@ -60,37 +266,241 @@ func newDirector(b Builder) *Director{
 func (d *Director) SetBuilder(b Builder) {
 	d.builder = b
 }
-func (d *Director) BuildMinimalViableProduct() {
+func (d *Director) finish(recipe string) (Product1, error) {
+	product, err := d.builder.GetProduct()
+	if err != nil {
+		return Product1{}, &RecipeError{Recipe: recipe, Err: err}
+	}
+	return product, nil
+}
+func (d *Director) BuildMinimalViableProduct() (Product1, error) {
 	d.builder.ProducePartA()
+	return d.finish("minimal viable product")
 }
-func (d *Director) BuildFullFeaturedProduct() {
+func (d *Director) BuildFullFeaturedProduct() (Product1, error) {
 	d.builder.ProducePartA()
 	d.builder.ProducePartB()
 	d.builder.ProducePartC()
+	return d.finish("full featured product")
+}
+func (d *Director) BuildAccessoryOnlyProduct() (Product1, error) {
+	d.builder.ProducePartC()
+	return d.finish("accessory only product")
+}
+
+// LoadRecipes reads a JSON recipe file and validates every recipe in it
+// before any of them become available
+func (d *Director) LoadRecipes(r io.Reader) error {
+	var file recipeFile
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		return fmt.Errorf("reading recipes: %w", err)
+	}
+	recipes := map[string]Recipe{}
+	var errs []error
+	for _, recipe := range file.Recipes {
+		if _, exists := recipes[recipe.Name]; exists {
+			errs = append(errs, fmt.Errorf("recipe %s defined twice", recipe.Name))
+			continue
+		}
+		if err := recipe.validate(getBuilder); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		recipes[recipe.Name] = recipe
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	d.recipes = recipes
+	return nil
+}
+func (d *Director) Recipes() []Recipe {
+	names := make([]string, 0, len(d.recipes))
+	for name := range d.recipes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	recipes := make([]Recipe, len(names))
+	for i, name := range names {
+		recipes[i] = d.recipes[name]
+	}
+	return recipes
+}
+func (d *Director) BuildRecipe(name string, with ...string) (Product1, error) {
+	recipe, ok := d.recipes[name]
+	if !ok {
+		names := make([]string, 0, len(d.recipes))
+		for _, r := range d.Recipes() {
+			names = append(names, r.Name)
+		}
+		return Product1{}, fmt.Errorf("unknown recipe %q (known recipes: %s)", name, strings.Join(names, ", "))
+	}
+	return d.build(recipe, with...)
+}
+func (d *Director) build(recipe Recipe, with ...string) (Product1, error) {
+	if err := recipe.run(d.builder, with); err != nil {
+		return Product1{}, &RecipeError{Recipe: recipe.Name, Err: err}
+	}
+	return d.finish(recipe.Name)
+}
+
+// Batch Director
+// Builds many products concurrently; every worker owns a Director with its
+// own Builder, so no builder is ever shared between goroutines
+type BatchResult struct{
+	Recipe string
+	Product Product1
+	Err error
+}
+
+type BatchDirector struct{
+	newBuilder func() Builder
+	workers int
+}
+func newBatchDirector(newBuilder func() Builder, workers int) *BatchDirector{
+	return &BatchDirector{newBuilder: newBuilder, workers: max(workers, 1)}
+}
+
+// Build returns one result per recipe, in input order. Once ctx is done the
+// remaining recipes are not built and report ctx.Err()
+func (b *BatchDirector) Build(ctx context.Context, recipes []Recipe) []BatchResult {
+	results := make([]BatchResult, len(recipes))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(b.workers, len(recipes)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			director := newDirector(b.newBuilder())
+			for i := range jobs {
+				if err := ctx.Err(); err != nil {
+					results[i] = BatchResult{Recipe: recipes[i].Name, Err: err}
+					continue
+				}
+				product, err := director.build(recipes[i])
+				results[i] = BatchResult{Recipe: recipes[i].Name, Product: product, Err: err}
+			}
+		}()
+	}
+	for i := range recipes {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			results[i] = BatchResult{Recipe: recipes[i].Name, Err: ctx.Err()}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return results
 }
 
 // Client Code
+func printProduct(product Product1, err error){
+	var recipeErr *RecipeError
+	var validationErr *ValidationError
+	switch {
+	case errors.As(err, &recipeErr) && errors.As(err, &validationErr):
+		fmt.Println("Recipe " + recipeErr.Recipe + " broke:")
+		for _, v := range validationErr.Violations {
+			fmt.Println("  " + v.String())
+		}
+	case err != nil:
+		fmt.Println("Error: " + err.Error())
+	default:
+		product.listParts()
+	}
+}
+
 func ClientCode(director Director){
 	builder := getBuilder()
 	director.SetBuilder(builder)
 
 	fmt.Println("Standard basic product:")
-	director.BuildMinimalViableProduct()
-	builder.GetProduct().listParts()
+	printProduct(director.BuildMinimalViableProduct())
 	fmt.Println("")
 
 	fmt.Println("Standard full featured product:")
-	director.BuildFullFeaturedProduct()
-	builder.GetProduct().listParts()
+	printProduct(director.BuildFullFeaturedProduct())
+	fmt.Println("")
+
+	fmt.Println("Broken accessory only product:")
+	printProduct(director.BuildAccessoryOnlyProduct())
 	fmt.Println("")
 
 	fmt.Println("Custom product:")
 	builder.ProducePartA()
 	builder.ProducePartC()
-	builder.GetProduct().listParts()
+	printProduct(builder.GetProduct())
 }
 
 // Main function
 func main() {
-    ClientCode(Director{})
+	recipesPath := flag.String("recipes", "", "JSON recipe file (defaults to the built-in recipes)")
+	list := flag.Bool("list", false, "list the available recipes")
+	recipeName := flag.String("recipe", "", "build the named recipe")
+	with := flag.String("with", "", "comma separated optional parts to include")
+	batch := flag.Int("batch", 0, "build this many products from the recipes concurrently")
+	workers := flag.Int("workers", 8, "number of concurrent builders for -batch")
+	flag.Parse()
+
+	if *recipesPath == "" && !*list && *recipeName == "" && *batch == 0 {
+		ClientCode(Director{})
+		return
+	}
+
+	director := newDirector(getBuilder())
+	var source io.Reader = strings.NewReader(defaultRecipes)
+	if *recipesPath != "" {
+		file, err := os.Open(*recipesPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		source = file
+	}
+	if err := director.LoadRecipes(source); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *list {
+		for _, recipe := range director.Recipes() {
+			fmt.Printf("%-10s %s\n", recipe.Name, recipe.Description)
+		}
+	}
+	if *recipeName != "" {
+		var parts []string
+		if *with != "" {
+			parts = strings.Split(*with, ",")
+		}
+		product, err := director.BuildRecipe(*recipeName, parts...)
+		if err != nil {
+			printProduct(product, err)
+			os.Exit(1)
+		}
+		product.listParts()
+	}
+	if *batch > 0 {
+		available := director.Recipes()
+		if len(available) == 0 {
+			fmt.Fprintln(os.Stderr, "no recipes to build a batch from")
+			os.Exit(1)
+		}
+		recipes := make([]Recipe, *batch)
+		for i := range recipes {
+			recipes[i] = available[i%len(available)]
+		}
+		results := newBatchDirector(getBuilder, *workers).Build(context.Background(), recipes)
+		parts, failed := 0, 0
+		for _, result := range results {
+			if result.Err != nil {
+				failed++
+				continue
+			}
+			parts += len(result.Product.parts)
+		}
+		fmt.Printf("Built %d products (%d parts) with %d workers, %d failed\n", len(results)-failed, parts, *workers, failed)
+	}
 }
\ No newline at end of file
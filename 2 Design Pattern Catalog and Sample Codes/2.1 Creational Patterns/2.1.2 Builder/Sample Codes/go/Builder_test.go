@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestValidateTriesEveryOptionalCombination(t *testing.T) {
+	// fine with no options and with all of them, but PartC alone comes
+	// before any PartA
+	recipe := Recipe{Name: "tricky", Steps: []RecipeStep{
+		{Part: "PartA", Optional: true},
+		{Part: "PartC", Optional: true},
+		{Part: "PartA"},
+	}}
+	err := recipe.validate(getBuilder)
+	var recipeErr *RecipeError
+	if !errors.As(err, &recipeErr) || !strings.Contains(err.Error(), "with PartC") {
+		t.Errorf("validate() = %v, want a RecipeError for the PartC-only combination", err)
+	}
+}
+
+func TestBuildRecipeRejectsUnknownOptions(t *testing.T) {
+	director := newDirector(getBuilder())
+	if err := director.LoadRecipes(strings.NewReader(defaultRecipes)); err != nil {
+		t.Fatal(err)
+	}
+	for _, with := range []string{"PartB", "PartD", "partc"} {
+		if _, err := director.BuildRecipe("bundle", with); err == nil {
+			t.Errorf("BuildRecipe(bundle, %q) succeeded", with)
+		}
+	}
+	product, err := director.BuildRecipe("bundle", "PartC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(product.parts, ","); got != "PartA1,PartA1,PartC1" {
+		t.Errorf("bundle with PartC built %s", got)
+	}
+}
+
+func batchRecipes(t *testing.T) []Recipe {
+	t.Helper()
+	director := newDirector(getBuilder())
+	if err := director.LoadRecipes(strings.NewReader(defaultRecipes)); err != nil {
+		t.Fatal(err)
+	}
+	return director.Recipes()
+}
+
+func TestBatchKeepsInputOrder(t *testing.T) {
+	available := batchRecipes(t)
+	recipes := make([]Recipe, 300)
+	for i := range recipes {
+		recipes[i] = available[i%len(available)]
+	}
+	results := newBatchDirector(getBuilder, 8).Build(context.Background(), recipes)
+	if len(results) != len(recipes) {
+		t.Fatalf("got %d results for %d recipes", len(results), len(recipes))
+	}
+	want := map[string]int{"bundle": 2, "full": 3, "minimal": 1}
+	for i, result := range results {
+		if result.Err != nil || result.Recipe != recipes[i].Name || len(result.Product.parts) != want[result.Recipe] {
+			t.Fatalf("result %d = %+v, want recipe %s", i, result, recipes[i].Name)
+		}
+	}
+}
+
+func TestBatchReportsErrorsPerItem(t *testing.T) {
+	minimal := Recipe{Name: "minimal", Steps: []RecipeStep{{Part: "PartA"}}}
+	unknown := Recipe{Name: "unknown", Steps: []RecipeStep{{Part: "PartZ"}}}
+	accessory := Recipe{Name: "accessory", Steps: []RecipeStep{{Part: "PartC"}}}
+	recipes := []Recipe{minimal, unknown, minimal, accessory, minimal}
+	results := newBatchDirector(getBuilder, 2).Build(context.Background(), recipes)
+	for i, result := range results {
+		failed := recipes[i].Name != "minimal"
+		if (result.Err != nil) != failed {
+			t.Errorf("result %d (%s): error = %v", i, result.Recipe, result.Err)
+		}
+	}
+	var validation *ValidationError
+	if !errors.As(results[3].Err, &validation) {
+		t.Errorf("accessory error = %v, want a ValidationError", results[3].Err)
+	}
+}
+
+// cancellingBuilder cancels the batch once it has built after products
+type cancellingBuilder struct {
+	Builder
+	built  *atomic.Int32
+	after  int32
+	cancel context.CancelFunc
+}
+
+func (b cancellingBuilder) GetProduct() (Product1, error) {
+	if b.built.Add(1) == b.after {
+		b.cancel()
+	}
+	return b.Builder.GetProduct()
+}
+
+func TestBatchCancelledMidway(t *testing.T) {
+	recipes := make([]Recipe, 100)
+	for i := range recipes {
+		recipes[i] = Recipe{Name: "minimal", Steps: []RecipeStep{{Part: "PartA"}}}
+	}
+	for _, workers := range []int{1, 8} {
+		ctx, cancel := context.WithCancel(context.Background())
+		var built atomic.Int32
+		newBuilder := func() Builder {
+			return cancellingBuilder{Builder: getBuilder(), built: &built, after: 10, cancel: cancel}
+		}
+		results := newBatchDirector(newBuilder, workers).Build(ctx, recipes)
+		cancel()
+		succeeded := 0
+		for i, result := range results {
+			switch {
+			case result.Err == nil:
+				succeeded++
+			case !errors.Is(result.Err, context.Canceled):
+				t.Errorf("%d workers, result %d: %v", workers, i, result.Err)
+			}
+		}
+		if succeeded < 10 || succeeded >= len(recipes) {
+			t.Errorf("%d workers: %d of %d recipes built after cancelling at 10", workers, succeeded, len(recipes))
+		}
+		if workers == 1 && (results[9].Err != nil || results[10].Err == nil) {
+			t.Errorf("one worker: cancelling after the 10th product did not stop at the 11th")
+		}
+	}
+}
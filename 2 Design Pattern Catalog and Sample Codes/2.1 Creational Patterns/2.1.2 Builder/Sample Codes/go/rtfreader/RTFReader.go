@@ -0,0 +1,587 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Style is the character formatting the reader tracks
+type Style struct {
+	Bold   bool
+	Italic bool
+}
+
+// Builder interface (TextConverter in the book)
+type TextConverter interface {
+	ConvertCharacter(c rune)
+	ConvertFontChange(font string)
+	ConvertStyleChange(style Style)
+	ConvertParagraph()
+	GetText() string
+}
+
+// Concrete Builders
+
+// PlainTextConverter ignores everything except characters and paragraphs
+// (the ASCIIConverter of the book)
+type PlainTextConverter struct {
+	text strings.Builder
+}
+
+func (c *PlainTextConverter) ConvertCharacter(r rune) {
+	c.text.WriteRune(r)
+}
+func (c *PlainTextConverter) ConvertFontChange(font string)  {}
+func (c *PlainTextConverter) ConvertStyleChange(style Style) {}
+func (c *PlainTextConverter) ConvertParagraph() {
+	c.text.WriteString("\n")
+}
+func (c *PlainTextConverter) GetText() string {
+	text := c.text.String()
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	return text
+}
+
+// inlineMarkup describes how a markup language spells styles and characters
+type inlineMarkup interface {
+	open(style Style, font string) string
+	close(style Style, font string) string
+	escape(r rune) string
+}
+
+// styledText does the bookkeeping shared by the markup converters. Markup is
+// opened lazily before the next visible character, and whitespace is kept
+// outside of it, so "**bold **" never happens
+type styledText struct {
+	markup       inlineMarkup
+	paragraphs   []string
+	current      strings.Builder
+	pendingSpace strings.Builder
+	wantedStyle  Style
+	wantedFont   string
+	activeStyle  Style
+	activeFont   string
+}
+
+func (t *styledText) character(r rune) {
+	if unicode.IsSpace(r) {
+		t.pendingSpace.WriteString(t.markup.escape(r))
+		return
+	}
+	if t.wantedStyle != t.activeStyle || t.wantedFont != t.activeFont {
+		t.current.WriteString(t.markup.close(t.activeStyle, t.activeFont))
+		t.current.WriteString(t.pendingSpace.String())
+		t.current.WriteString(t.markup.open(t.wantedStyle, t.wantedFont))
+		t.activeStyle, t.activeFont = t.wantedStyle, t.wantedFont
+	} else {
+		t.current.WriteString(t.pendingSpace.String())
+	}
+	t.pendingSpace.Reset()
+	t.current.WriteString(t.markup.escape(r))
+}
+
+func (t *styledText) paragraph() {
+	t.current.WriteString(t.markup.close(t.activeStyle, t.activeFont))
+	t.activeStyle, t.activeFont = Style{}, ""
+	t.pendingSpace.Reset()
+	if t.current.Len() > 0 {
+		t.paragraphs = append(t.paragraphs, t.current.String())
+	}
+	t.current.Reset()
+}
+
+func (t *styledText) finish() []string {
+	t.paragraph()
+	return t.paragraphs
+}
+
+// TeXConverter captures the styles and fonts as LaTeX commands
+type TeXConverter struct {
+	styledText
+}
+
+type texMarkup struct{}
+
+func NewTeXConverter() *TeXConverter {
+	return &TeXConverter{styledText{markup: texMarkup{}}}
+}
+func (c *TeXConverter) ConvertCharacter(r rune) {
+	c.character(r)
+}
+func (c *TeXConverter) ConvertFontChange(font string) {
+	c.wantedFont = font
+}
+func (c *TeXConverter) ConvertStyleChange(style Style) {
+	c.wantedStyle = style
+}
+func (c *TeXConverter) ConvertParagraph() {
+	c.paragraph()
+}
+func (c *TeXConverter) GetText() string {
+	return strings.Join(c.finish(), "\n\n") + "\n"
+}
+
+func (texMarkup) open(style Style, font string) string {
+	var markup string
+	if font != "" {
+		markup += "{\\fontfamily{" + font + "}\\selectfont "
+	}
+	if style.Bold {
+		markup += "\\textbf{"
+	}
+	if style.Italic {
+		markup += "\\textit{"
+	}
+	return markup
+}
+func (texMarkup) close(style Style, font string) string {
+	var markup string
+	if style.Italic {
+		markup += "}"
+	}
+	if style.Bold {
+		markup += "}"
+	}
+	if font != "" {
+		markup += "}"
+	}
+	return markup
+}
+func (texMarkup) escape(r rune) string {
+	switch r {
+	case '\\':
+		return "\\textbackslash{}"
+	case '{', '}', '$', '&', '#', '_', '%':
+		return "\\" + string(r)
+	case '^':
+		return "\\^{}"
+	case '~':
+		return "\\~{}"
+	case '\u00a0':
+		return "~"
+	}
+	return string(r)
+}
+
+// HTMLConverter renders paragraphs, <strong>, <em> and font-family spans
+type HTMLConverter struct {
+	styledText
+}
+
+type htmlMarkup struct{}
+
+func NewHTMLConverter() *HTMLConverter {
+	return &HTMLConverter{styledText{markup: htmlMarkup{}}}
+}
+func (c *HTMLConverter) ConvertCharacter(r rune) {
+	c.character(r)
+}
+func (c *HTMLConverter) ConvertFontChange(font string) {
+	c.wantedFont = font
+}
+func (c *HTMLConverter) ConvertStyleChange(style Style) {
+	c.wantedStyle = style
+}
+func (c *HTMLConverter) ConvertParagraph() {
+	c.paragraph()
+}
+func (c *HTMLConverter) GetText() string {
+	var text strings.Builder
+	for _, paragraph := range c.finish() {
+		text.WriteString("<p>" + paragraph + "</p>\n")
+	}
+	return text.String()
+}
+
+func (htmlMarkup) open(style Style, font string) string {
+	var markup string
+	if font != "" {
+		markup += `<span style="font-family: ` + html.EscapeString(font) + `">`
+	}
+	if style.Bold {
+		markup += "<strong>"
+	}
+	if style.Italic {
+		markup += "<em>"
+	}
+	return markup
+}
+func (htmlMarkup) close(style Style, font string) string {
+	var markup string
+	if style.Italic {
+		markup += "</em>"
+	}
+	if style.Bold {
+		markup += "</strong>"
+	}
+	if font != "" {
+		markup += "</span>"
+	}
+	return markup
+}
+func (htmlMarkup) escape(r rune) string {
+	if r == '\u00a0' {
+		return "&nbsp;"
+	}
+	return html.EscapeString(string(r))
+}
+
+// MarkdownConverter has no notion of fonts, so it only keeps bold and italic
+type MarkdownConverter struct {
+	styledText
+}
+
+type markdownMarkup struct{}
+
+func NewMarkdownConverter() *MarkdownConverter {
+	return &MarkdownConverter{styledText{markup: markdownMarkup{}}}
+}
+func (c *MarkdownConverter) ConvertCharacter(r rune) {
+	c.character(r)
+}
+func (c *MarkdownConverter) ConvertFontChange(font string) {}
+func (c *MarkdownConverter) ConvertStyleChange(style Style) {
+	c.wantedStyle = style
+}
+func (c *MarkdownConverter) ConvertParagraph() {
+	c.paragraph()
+}
+func (c *MarkdownConverter) GetText() string {
+	return strings.Join(c.finish(), "\n\n") + "\n"
+}
+
+func (markdownMarkup) open(style Style, font string) string {
+	var markup string
+	if style.Bold {
+		markup += "**"
+	}
+	if style.Italic {
+		markup += "*"
+	}
+	return markup
+}
+func (markdownMarkup) close(style Style, font string) string {
+	var markup string
+	if style.Italic {
+		markup += "*"
+	}
+	if style.Bold {
+		markup += "**"
+	}
+	return markup
+}
+func (markdownMarkup) escape(r rune) string {
+	if strings.ContainsRune("\\`*_[]#<>", r) {
+		return "\\" + string(r)
+	}
+	return string(r)
+}
+
+// Director
+// RTFReader parses a practical subset of RTF: groups, paragraphs, \b and \i,
+// font tables and \f, \' and \u escapes, and a few named characters.
+// Everything else is ignored; unknown destinations are skipped
+type RTFReader struct {
+	converter TextConverter
+}
+
+func NewRTFReader(converter TextConverter) *RTFReader {
+	return &RTFReader{converter: converter}
+}
+
+type groupState struct {
+	style       Style
+	font        int
+	destination string
+	unicodeSkip int
+}
+
+type rtfParser struct {
+	in          *bufio.Reader
+	converter   TextConverter
+	groups      []groupState
+	fonts       map[int]string
+	fontNumber  int
+	fontName    strings.Builder
+	emitted     Style
+	emittedFont string
+	skip        int
+}
+
+// destinations whose content is not part of the document text
+var skippedDestinations = map[string]bool{
+	"colortbl": true, "stylesheet": true, "info": true, "pict": true,
+	"header": true, "footer": true, "object": true, "listtable": true,
+}
+
+// named characters mapped to their Unicode equivalents
+var namedCharacters = map[string]rune{
+	"tab": '\t', "emdash": '—', "endash": '–', "bullet": '•',
+	"lquote": '‘', "rquote": '’', "ldblquote": '“', "rdblquote": '”',
+}
+
+// windows-1252 differs from Latin-1 in 0x80-0x9F; these are the common ones
+var cp1252 = map[byte]rune{
+	0x80: '€', 0x85: '…', 0x91: '‘', 0x92: '’',
+	0x93: '“', 0x94: '”', 0x95: '•', 0x96: '–', 0x97: '—',
+}
+
+func (reader *RTFReader) ParseRTF(in io.Reader) error {
+	p := &rtfParser{
+		in:        bufio.NewReader(in),
+		converter: reader.converter,
+		groups:    []groupState{{font: -1, unicodeSkip: 1}},
+		fonts:     map[int]string{},
+	}
+	return p.parse()
+}
+
+func (p *rtfParser) top() *groupState {
+	return &p.groups[len(p.groups)-1]
+}
+
+func (p *rtfParser) parse() error {
+	for {
+		b, err := p.in.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch b {
+		case '{':
+			p.groups = append(p.groups, *p.top())
+		case '}':
+			if len(p.groups) == 1 {
+				return errors.New("rtf: unbalanced '}'")
+			}
+			if p.top().destination == "fonttbl" && p.groups[len(p.groups)-2].destination != "fonttbl" {
+				p.fontName.Reset()
+			}
+			p.groups = p.groups[:len(p.groups)-1]
+		case '\\':
+			if err := p.control(); err != nil {
+				return err
+			}
+		case '\r', '\n':
+		default:
+			p.text(rune(b))
+		}
+	}
+	if len(p.groups) > 1 {
+		return fmt.Errorf("rtf: %d unclosed group(s)", len(p.groups)-1)
+	}
+	return nil
+}
+
+func (p *rtfParser) control() error {
+	b, err := p.in.ReadByte()
+	if err != nil {
+		return errors.New("rtf: document ends in a backslash")
+	}
+	switch {
+	case b == '\\' || b == '{' || b == '}':
+		p.text(rune(b))
+	case b == '~':
+		p.text('\u00a0')
+	case b == '_':
+		p.text('‑')
+	case b == '-':
+	case b == '*':
+		p.top().destination = "*"
+	case b == '\r' || b == '\n':
+		p.word("par", 0, false)
+	case b == '\'':
+		hex := make([]byte, 2)
+		if _, err := io.ReadFull(p.in, hex); err != nil {
+			return errors.New("rtf: truncated \\' escape")
+		}
+		value, err := strconv.ParseUint(string(hex), 16, 8)
+		if err != nil {
+			return fmt.Errorf("rtf: bad \\' escape %q", hex)
+		}
+		if r, ok := cp1252[byte(value)]; ok {
+			p.text(r)
+		} else {
+			p.text(rune(value))
+		}
+	case isLetter(b):
+		name := []byte{b}
+		for {
+			next, err := p.in.ReadByte()
+			if err != nil {
+				break
+			}
+			if !isLetter(next) {
+				p.in.UnreadByte()
+				break
+			}
+			name = append(name, next)
+		}
+		param, hasParam := p.parameter()
+		if next, err := p.in.ReadByte(); err == nil && next != ' ' {
+			p.in.UnreadByte()
+		}
+		p.word(string(name), param, hasParam)
+	}
+	return nil
+}
+
+func (p *rtfParser) parameter() (int, bool) {
+	var digits []byte
+	if next, err := p.in.ReadByte(); err == nil {
+		if next == '-' || (next >= '0' && next <= '9') {
+			digits = append(digits, next)
+		} else {
+			p.in.UnreadByte()
+			return 0, false
+		}
+	}
+	for {
+		next, err := p.in.ReadByte()
+		if err != nil {
+			break
+		}
+		if next < '0' || next > '9' {
+			p.in.UnreadByte()
+			break
+		}
+		digits = append(digits, next)
+	}
+	value, err := strconv.Atoi(string(digits))
+	return value, err == nil
+}
+
+func (p *rtfParser) word(name string, param int, hasParam bool) {
+	state := p.top()
+	if state.destination == "*" || skippedDestinations[state.destination] {
+		return
+	}
+	if skippedDestinations[name] {
+		state.destination = name
+		return
+	}
+	switch name {
+	case "fonttbl":
+		state.destination = "fonttbl"
+	case "f":
+		if state.destination == "fonttbl" {
+			p.fontNumber = param
+			p.fontName.Reset()
+		} else {
+			state.font = param
+		}
+	case "deff":
+		state.font = param
+	case "b":
+		state.style.Bold = !hasParam || param != 0
+	case "i":
+		state.style.Italic = !hasParam || param != 0
+	case "plain":
+		state.style = Style{}
+	case "par":
+		p.skip = 0
+		p.converter.ConvertParagraph()
+	case "uc":
+		state.unicodeSkip = param
+	case "u":
+		if param < 0 {
+			param += 65536
+		}
+		p.skip = 0
+		p.text(rune(param))
+		p.skip = state.unicodeSkip
+	default:
+		if r, ok := namedCharacters[name]; ok {
+			p.text(r)
+		}
+	}
+}
+
+func (p *rtfParser) text(r rune) {
+	state := p.top()
+	switch {
+	case state.destination == "fonttbl":
+		if r == ';' {
+			p.fonts[p.fontNumber] = strings.TrimSpace(p.fontName.String())
+			p.fontName.Reset()
+		} else {
+			p.fontName.WriteRune(r)
+		}
+		return
+	case state.destination != "":
+		return
+	case p.skip > 0:
+		// the fallback characters that follow \u
+		p.skip--
+		return
+	}
+	font := p.fonts[state.font]
+	if font != p.emittedFont {
+		p.converter.ConvertFontChange(font)
+		p.emittedFont = font
+	}
+	if state.style != p.emitted {
+		p.converter.ConvertStyleChange(state.style)
+		p.emitted = state.style
+	}
+	p.converter.ConvertCharacter(r)
+}
+
+func isLetter(b byte) bool {
+	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+// Client code
+var converters = map[string]func() TextConverter{
+	"txt":  func() TextConverter { return &PlainTextConverter{} },
+	"tex":  func() TextConverter { return NewTeXConverter() },
+	"html": func() TextConverter { return NewHTMLConverter() },
+	"md":   func() TextConverter { return NewMarkdownConverter() },
+}
+
+func convert(document []byte, format string) (string, error) {
+	newConverter, ok := converters[format]
+	if !ok {
+		return "", fmt.Errorf("unknown format %q (want txt, tex, html or md)", format)
+	}
+	converter := newConverter()
+	if err := NewRTFReader(converter).ParseRTF(bytes.NewReader(document)); err != nil {
+		return "", err
+	}
+	return converter.GetText(), nil
+}
+
+// Main function
+// go run RTFReader.go -format html document.rtf
+func main() {
+	format := flag.String("format", "txt", "output format: txt, tex, html or md")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: RTFReader [-format txt|tex|html|md] document.rtf")
+		os.Exit(2)
+	}
+	document, err := os.ReadFile(flag.Arg(0))
+	if err == nil {
+		var text string
+		text, err = convert(document, *format)
+		fmt.Print(text)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden outputs instead of checking them")
+
+// TestGolden converts every testdata/*.rtf to every format and compares the
+// result with the file next to it, e.g. letter.rtf -> letter.html
+func TestGolden(t *testing.T) {
+	documents, err := filepath.Glob(filepath.Join("testdata", "*.rtf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(documents) == 0 {
+		t.Fatal("no .rtf documents in testdata")
+	}
+	for _, document := range documents {
+		source, err := os.ReadFile(document)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, format := range []string{"txt", "tex", "html", "md"} {
+			golden := strings.TrimSuffix(document, ".rtf") + "." + format
+			t.Run(filepath.Base(golden), func(t *testing.T) {
+				got, err := convert(source, format)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if *update {
+					if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+						t.Fatal(err)
+					}
+					return
+				}
+				want, err := os.ReadFile(golden)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != string(want) {
+					t.Errorf("--- got ---\n%s--- want ---\n%s", got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestDefaultFont(t *testing.T) {
+	document := `{\rtf1\ansi\deff1{\fonttbl{\f0 Times;}{\f1 Courier New;}}Code\par}`
+	got, err := convert([]byte(document), "html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got, "Courier New") {
+		t.Errorf("\\deff1 did not select font 1:\n%s", got)
+	}
+}
@@ -1,7 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 // Product interface
@@ -9,11 +19,15 @@ type Product interface{
 	Operation() string
 }
 // Concrete products
-type ConcreteProduct1 struct{}
+type ConcreteProduct1 struct{
+	Label string `json:"label,omitempty"`
+}
 func (p *ConcreteProduct1) Operation() string{
 	return "{Result of the ConcreteProduct1}"
 }
-type ConcreteProduct2 struct{}
+type ConcreteProduct2 struct{
+	Count int `json:"count,omitempty"`
+}
 func (p *ConcreteProduct2) Operation() string{
 	return "{Result of the ConcreteProduct2}"
 }
@@ -33,10 +47,312 @@ func (c *ConcreteCreator1) FactoryMethod() Product{
 func newConcreteCreator1() *ConcreteCreator1{
 	return &ConcreteCreator1{}
 }
+// Each creator registers itself under the kind of product it makes
+func init(){
+	RegisterCreator[*ConcreteProduct1]("product1", func() Creator { return newConcreteCreator1() })
+}
 type ConcreteCreator2 struct{}
 func (c *ConcreteCreator2) FactoryMethod() Product{
 	return &ConcreteProduct2{}
 }
+func init(){
+	RegisterCreator[*ConcreteProduct2]("product2", func() Creator { return &ConcreteCreator2{} })
+}
+// Creator registry
+var (
+	registryLock sync.RWMutex
+	creators = map[string]func() Creator{}
+	productKinds = map[reflect.Type]string{}
+)
+// RegisterCreator also records P, the type of product the creator makes, so
+// a product can be mapped back to its kind without calling a factory method
+func RegisterCreator[P Product](kind string, newCreator func() Creator){
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if _, exists := creators[kind]; exists {
+		panic("factory method: creator for " + kind + " registered twice")
+	}
+	productType := reflect.TypeFor[P]()
+	if other, exists := productKinds[productType]; exists {
+		panic("factory method: " + productType.String() + " is already made by " + other)
+	}
+	creators[kind] = newCreator
+	productKinds[productType] = kind
+}
+func LookupCreator(kind string) (Creator, error){
+	registryLock.RLock()
+	newCreator, ok := creators[kind]
+	registryLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no creator for product kind %q (known kinds: %s)", kind, strings.Join(Kinds(), ", "))
+	}
+	return newCreator(), nil
+}
+// Kinds lists the registered product kinds in sorted order
+func Kinds() []string{
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	kinds := make([]string, 0, len(creators))
+	for kind := range creators {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+// Product codec
+// JSON documents name their product in a discriminator field; the codec asks
+// the registered creator for an empty product and decodes into it
+type ProductCodec struct{
+	field string
+	aliases map[string]productAlias
+}
+// productAlias maps an old type name to a current kind, optionally rewriting
+// the old payload first
+type productAlias struct{
+	kind string
+	migrate func(fields map[string]json.RawMessage)
+}
+func NewProductCodec(field string) *ProductCodec{
+	return &ProductCodec{field: field, aliases: map[string]productAlias{}}
+}
+// Alias keeps payloads written with an older type name decodable
+func (c *ProductCodec) Alias(name string, kind string, migrate func(fields map[string]json.RawMessage)){
+	c.aliases[name] = productAlias{kind: kind, migrate: migrate}
+}
+func (c *ProductCodec) typeNames() []string{
+	names := Kinds()
+	for name := range c.aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+func (c *ProductCodec) Decode(data []byte) (Product, error){
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("decoding product: %w", err)
+	}
+	raw, ok := fields[c.field]
+	if !ok {
+		return nil, fmt.Errorf("decoding product: missing %q field", c.field)
+	}
+	var name string
+	if err := json.Unmarshal(raw, &name); err != nil {
+		return nil, fmt.Errorf("decoding product: %q field must be a string, got %s", c.field, raw)
+	}
+	kind := name
+	if alias, ok := c.aliases[name]; ok {
+		kind = alias.kind
+		if alias.migrate != nil {
+			alias.migrate(fields)
+			migrated, err := json.Marshal(fields)
+			if err != nil {
+				return nil, err
+			}
+			data = migrated
+		}
+	}
+	creator, err := LookupCreator(kind)
+	if err != nil {
+		return nil, fmt.Errorf("decoding product: unknown %s %q (known: %s)", c.field, name, strings.Join(c.typeNames(), ", "))
+	}
+	product := creator.FactoryMethod()
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(product); err != nil {
+		return nil, fmt.Errorf("decoding %s product: %w", kind, err)
+	}
+	return product, nil
+}
+// Encode writes the product with its current type name as the first field
+func (c *ProductCodec) Encode(product Product) ([]byte, error){
+	kind, err := kindOf(product)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(product)
+	if err != nil {
+		return nil, err
+	}
+	// the discriminator is spliced into the body, so it has to be an object
+	// that does not already use the field
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil || fields == nil {
+		return nil, fmt.Errorf("encoding %s product: want a JSON object, got %s", kind, body)
+	}
+	if _, clash := fields[c.field]; clash {
+		return nil, fmt.Errorf("encoding %s product: it already has a %q field", kind, c.field)
+	}
+	discriminator, _ := json.Marshal(map[string]string{c.field: kind})
+	if string(body) == "{}" {
+		return discriminator, nil
+	}
+	return append(discriminator[:len(discriminator)-1], append([]byte(","), body[1:]...)...), nil
+}
+// kindOf finds the registered kind whose creator makes products of this type
+func kindOf(product Product) (string, error){
+	registryLock.RLock()
+	kind, ok := productKinds[reflect.TypeOf(product)]
+	registryLock.RUnlock()
+	if !ok {
+		return "", errors.New("encoding product: no creator registered for " + reflect.TypeOf(product).String())
+	}
+	return kind, nil
+}
+
+// Product lifecycle
+// InitHook runs on every freshly made product before anyone sees it.
+// Products that hold resources implement io.Closer
+type InitHook func(product Product) error
+
+func closeProduct(product Product) error{
+	if closer, ok := product.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// ManagedCreator runs the init hooks after the factory method
+type ManagedCreator struct{
+	creator Creator
+	hooks []InitHook
+}
+func NewManagedCreator(creator Creator, hooks ...InitHook) *ManagedCreator{
+	return &ManagedCreator{creator: creator, hooks: hooks}
+}
+func (m *ManagedCreator) Create() (Product, error){
+	product := m.creator.FactoryMethod()
+	for _, hook := range m.hooks {
+		if err := hook(product); err != nil {
+			return nil, errors.Join(fmt.Errorf("initialising product: %w", err), closeProduct(product))
+		}
+	}
+	return product, nil
+}
+
+// PoolStats is a snapshot of a PooledCreator
+type PoolStats struct{
+	InUse int
+	Idle int
+	Created int
+	Destroyed int
+}
+
+// PooledCreator hands out idle products before making new ones and keeps at
+// most maxIdle of them around; the rest are closed when returned. It tracks
+// what it handed out, so pooled products must be comparable, e.g. pointers
+type PooledCreator struct{
+	managed *ManagedCreator
+	maxIdle int
+	mu sync.Mutex
+	idle []Product
+	inUse map[Product]struct{}
+	stats PoolStats
+	closed bool
+}
+func NewPooledCreator(creator Creator, maxIdle int, hooks ...InitHook) *PooledCreator{
+	return &PooledCreator{managed: NewManagedCreator(creator, hooks...), maxIdle: maxIdle, inUse: map[Product]struct{}{}}
+}
+func (p *PooledCreator) Get() (Product, error){
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, errors.New("pool is closed")
+	}
+	if n := len(p.idle); n > 0 {
+		product := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.inUse[product] = struct{}{}
+		p.stats.Idle--
+		p.stats.InUse++
+		p.mu.Unlock()
+		return product, nil
+	}
+	p.mu.Unlock()
+
+	product, err := p.managed.Create()
+	if err != nil {
+		return nil, err
+	}
+	if !reflect.TypeOf(product).Comparable() {
+		return nil, errors.Join(fmt.Errorf("pool: %T products cannot be tracked", product), closeProduct(product))
+	}
+	p.mu.Lock()
+	p.inUse[product] = struct{}{}
+	p.stats.Created++
+	p.stats.InUse++
+	p.mu.Unlock()
+	return product, nil
+}
+// Put returns a product from Get; anything else, or a second Put of the same
+// product, is rejected and left alone
+func (p *PooledCreator) Put(product Product) error{
+	if product == nil || !reflect.TypeOf(product).Comparable() {
+		return fmt.Errorf("pool: %T was not handed out by this pool", product)
+	}
+	p.mu.Lock()
+	if _, ok := p.inUse[product]; !ok {
+		p.mu.Unlock()
+		return fmt.Errorf("pool: %T was not handed out by this pool or was already returned", product)
+	}
+	delete(p.inUse, product)
+	p.stats.InUse--
+	if !p.closed && len(p.idle) < p.maxIdle {
+		p.idle = append(p.idle, product)
+		p.stats.Idle++
+		p.mu.Unlock()
+		return nil
+	}
+	p.stats.Destroyed++
+	p.mu.Unlock()
+	return closeProduct(product)
+}
+func (p *PooledCreator) Stats() PoolStats{
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stats
+}
+// Close destroys the idle products; products still in use are closed when returned
+func (p *PooledCreator) Close() error{
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.closed = true
+	p.stats.Idle = 0
+	p.stats.Destroyed += len(idle)
+	p.mu.Unlock()
+	var errs []error
+	for _, product := range idle {
+		errs = append(errs, closeProduct(product))
+	}
+	return errors.Join(errs...)
+}
+
+// ConnectionProduct holds a resource that must be opened and closed
+type ConnectionProduct struct{
+	id int
+	open bool
+}
+func (p *ConnectionProduct) Operation() string{
+	return fmt.Sprintf("{Result of the ConnectionProduct %d}", p.id)
+}
+func (p *ConnectionProduct) Close() error{
+	p.open = false
+	fmt.Printf("Connection %d: closed\n", p.id)
+	return nil
+}
+type ConnectionCreator struct{
+	made atomic.Int64
+}
+func (c *ConnectionCreator) FactoryMethod() Product{
+	return &ConnectionProduct{id: int(c.made.Add(1))}
+}
+func openConnection(product Product) error{
+	connection := product.(*ConnectionProduct)
+	connection.open = true
+	fmt.Printf("Connection %d: opened\n", connection.id)
+	return nil
+}
+
 // Client Code
 func ClientCode(creator Creator){
 	fmt.Println("Client: I'm not aware of the creator's class, but it still works.")
@@ -44,9 +360,69 @@ func ClientCode(creator Creator){
 }
 // Main function
 func main() {
-	fmt.Println("App: Launched with the ConcreteCreator1.")
-    ClientCode(newConcreteCreator1())
+	kind := flag.String("kind", "", "product kind to create ("+strings.Join(Kinds(), ", ")+")")
+	flag.Parse()
+
+	if *kind != "" {
+		creator, err := LookupCreator(*kind)
+		if err != nil {
+			fmt.Println("App: " + err.Error())
+			return
+		}
+		fmt.Println("App: Launched with the creator for " + *kind + ".")
+		ClientCode(creator)
+		return
+	}
+	for i, kind := range Kinds() {
+		if i > 0 {
+			fmt.Println("")
+		}
+		creator, _ := LookupCreator(kind)
+		fmt.Println("App: Launched with the creator for " + kind + ".")
+		ClientCode(creator)
+	}
+
+	fmt.Println("")
+	fmt.Println("App: Decoding products from JSON.")
+	codec := NewProductCodec("type")
+	// product1 payloads used to be called "widget" and carried a "name"
+	codec.Alias("widget", "product1", func(fields map[string]json.RawMessage) {
+		if name, ok := fields["name"]; ok {
+			fields["label"] = name
+			delete(fields, "name")
+		}
+	})
+	payloads := []string{
+		`{"type": "product1", "label": "first"}`,
+		`{"count": 3, "type": "product2"}`,
+		`{"type": "widget", "name": "legacy"}`,
+		`{"type": "product3"}`,
+	}
+	for _, payload := range payloads {
+		product, err := codec.Decode([]byte(payload))
+		if err != nil {
+			fmt.Println("App: " + err.Error())
+			continue
+		}
+		encoded, _ := codec.Encode(product)
+		fmt.Println("App: " + product.Operation() + " re-encoded as " + string(encoded))
+	}
+
 	fmt.Println("")
-	fmt.Println("App: Launched with the ConcreteCreator2.")
-    ClientCode(&ConcreteCreator2{})
+	fmt.Println("App: Reusing products from a pool of at most 2 idle connections.")
+	pool := NewPooledCreator(&ConnectionCreator{}, 2, openConnection)
+	var borrowed []Product
+	for i := 0; i < 3; i++ {
+		product, _ := pool.Get()
+		borrowed = append(borrowed, product)
+	}
+	for _, product := range borrowed {
+		pool.Put(product)
+	}
+	product, _ := pool.Get()
+	fmt.Println("App: Reused " + product.Operation())
+	pool.Put(product)
+	fmt.Printf("App: Pool stats %+v\n", pool.Stats())
+	pool.Close()
+	fmt.Printf("App: Pool stats after close %+v\n", pool.Stats())
 }
\ No newline at end of file
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// labelled encodes with a field named like the codec's discriminator
+type labelled struct {
+	Type string `json:"type"`
+}
+
+func (l *labelled) Operation() string { return "labelled" }
+
+type labelledCreator struct{}
+
+func (labelledCreator) FactoryMethod() Product { return &labelled{} }
+
+// registerForTest adds a creator for the duration of one test
+func registerForTest[P Product](t *testing.T, kind string, newCreator func() Creator) {
+	t.Helper()
+	RegisterCreator[P](kind, newCreator)
+	t.Cleanup(func() {
+		registryLock.Lock()
+		defer registryLock.Unlock()
+		delete(creators, kind)
+		delete(productKinds, reflect.TypeFor[P]())
+	})
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	codec := NewProductCodec("type")
+	for _, product := range []Product{&ConcreteProduct1{Label: "a"}, &ConcreteProduct1{}, &ConcreteProduct2{Count: 3}} {
+		encoded, err := codec.Encode(product)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := codec.Decode(encoded)
+		if err != nil {
+			t.Fatalf("Decode(%s): %v", encoded, err)
+		}
+		again, _ := codec.Encode(decoded)
+		if string(again) != string(encoded) {
+			t.Errorf("%s came back as %s", encoded, again)
+		}
+	}
+}
+
+func TestEncodeRejectsNonObjects(t *testing.T) {
+	registerForTest[*labelled](t, "labelled", func() Creator { return labelledCreator{} })
+	codec := NewProductCodec("type")
+	for _, product := range []Product{(*ConcreteProduct1)(nil), &labelled{Type: "x"}} {
+		if encoded, err := codec.Encode(product); err == nil {
+			t.Errorf("Encode(%#v) = %s, want an error", product, encoded)
+		}
+	}
+}
+
+func TestPoolStats(t *testing.T) {
+	pool := NewPooledCreator(&ConnectionCreator{}, 1)
+	first, _ := pool.Get()
+	second, _ := pool.Get()
+	check := func(step string, want PoolStats) {
+		t.Helper()
+		if got := pool.Stats(); got != want {
+			t.Errorf("after %s: %+v, want %+v", step, got, want)
+		}
+	}
+	check("two Gets", PoolStats{InUse: 2, Created: 2})
+	pool.Put(first)
+	pool.Put(second)
+	check("two Puts", PoolStats{Idle: 1, Created: 2, Destroyed: 1})
+	if reused, _ := pool.Get(); reused != first {
+		t.Error("Get did not reuse the idle product")
+	}
+	check("reuse", PoolStats{InUse: 1, Created: 2, Destroyed: 1})
+	pool.Put(first)
+	pool.Close()
+	check("Close", PoolStats{Created: 2, Destroyed: 2})
+}
+
+func TestPoolRejectsForeignAndDoublePuts(t *testing.T) {
+	pool := NewPooledCreator(&ConnectionCreator{}, 4)
+	product, _ := pool.Get()
+	if err := pool.Put(product); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.Put(product); err == nil {
+		t.Error("a second Put of the same product succeeded")
+	}
+	if err := pool.Put(&ConnectionProduct{}); err == nil {
+		t.Error("Put of a product the pool never made succeeded")
+	}
+	if err := pool.Put(nil); err == nil {
+		t.Error("Put(nil) succeeded")
+	}
+	if got := pool.Stats(); got != (PoolStats{Idle: 1, Created: 1}) {
+		t.Errorf("rejected Puts changed the stats: %+v", got)
+	}
+}
+
+func TestPoolConcurrentUse(t *testing.T) {
+	pool := NewPooledCreator(&ConnectionCreator{}, 4)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				product, err := pool.Get()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if err := pool.Put(product); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	stats := pool.Stats()
+	if stats.InUse != 0 || stats.Idle > 4 || stats.Created != stats.Idle+stats.Destroyed {
+		t.Errorf("stats do not add up: %+v", stats)
+	}
+}
@@ -1,16 +1,51 @@
 package main
 
-import "fmt"
+import (
+    "bufio"
+    "bytes"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "io/fs"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+)
 
 // Prototype interface
 type Inode interface {
     print(string)
     clone() Inode
+    cloneWith(naming NamingPolicy) Inode
+    cloneShared(naming NamingPolicy, clones map[Inode]Inode) Inode
+    getName() string
+}
+
+// NamingPolicy decides what a cloned node is called
+type NamingPolicy func(name string) string
+
+// CloneSuffix is the policy clone() uses
+func CloneSuffix(name string) string {
+    return name + "_clone"
+}
+
+// KeepNames leaves names untouched
+func KeepNames(name string) string {
+    return name
 }
 
 // Concrete Prototype
 type File struct {
-    name string
+    name    string
+    content []byte
+    mode    fs.FileMode
 }
 
 func (f *File) print(indentation string) {
@@ -18,35 +53,978 @@ func (f *File) print(indentation string) {
 }
 
 func (f *File) clone() Inode {
-    return &File{name: f.name + "_clone"}
+    return f.cloneWith(CloneSuffix)
+}
+
+func (f *File) cloneWith(naming NamingPolicy) Inode {
+    return &File{name: naming(f.name), content: append([]byte(nil), f.content...), mode: f.mode}
+}
+
+func (f *File) cloneShared(naming NamingPolicy, clones map[Inode]Inode) Inode {
+    if clone, ok := clones[f]; ok {
+        return clone
+    }
+    clone := f.cloneWith(naming)
+    clones[f] = clone
+    return clone
+}
+
+func (f *File) getName() string {
+    return f.name
 }
 
 // Concrete prototype
 type Folder struct {
     children []Inode
     name     string
+    mode     fs.FileMode
 }
 
 func (f *Folder) print(indentation string) {
     fmt.Println(indentation + f.name)
     for _, i := range f.children {
-        i.print(indentation + indentation)
+        i.print(indentation + "  ")
     }
 }
 
 func (f *Folder) clone() Inode {
-    cloneFolder := &Folder{name: f.name + "_clone"}
+    return f.cloneWith(CloneSuffix)
+}
+
+// cloneWith copies every path separately, so it is only meant for trees;
+// use CloneGraph when nodes are shared or folders contain themselves
+func (f *Folder) cloneWith(naming NamingPolicy) Inode {
+    cloneFolder := &Folder{name: naming(f.name), mode: f.mode}
     var tempChildren []Inode
     for _, i := range f.children {
-        copy := i.clone()
+        copy := i.cloneWith(naming)
         tempChildren = append(tempChildren, copy)
     }
     cloneFolder.children = tempChildren
     return cloneFolder
 }
 
+func (f *Folder) cloneShared(naming NamingPolicy, clones map[Inode]Inode) Inode {
+    if clone, ok := clones[f]; ok {
+        return clone
+    }
+    // registered before the children so a cycle finds it
+    cloneFolder := &Folder{name: naming(f.name), mode: f.mode}
+    clones[f] = cloneFolder
+    for _, i := range f.children {
+        cloneFolder.children = append(cloneFolder.children, i.cloneShared(naming, clones))
+    }
+    return cloneFolder
+}
+
+func (f *Folder) getName() string {
+    return f.name
+}
+
+// CloneGraph deep-clones root but keeps its shape: a node reachable along
+// several paths is cloned once, like a hard link, and cycles are preserved
+func CloneGraph(root Inode, naming NamingPolicy) Inode {
+    return root.cloneShared(naming, map[Inode]Inode{})
+}
+
+// Prototype manager
+// Templates are registered under a key and cloned by key, with overrides
+type PrototypeManager struct {
+    prototypes map[string]Inode
+}
+
+func NewPrototypeManager() *PrototypeManager {
+    return &PrototypeManager{prototypes: map[string]Inode{}}
+}
+
+// Register stores a private copy, so later changes to the template don't leak in
+func (m *PrototypeManager) Register(key string, prototype Inode) {
+    m.prototypes[key] = CloneGraph(prototype, KeepNames)
+}
+
+func (m *PrototypeManager) Unregister(key string) {
+    delete(m.prototypes, key)
+}
+
+func (m *PrototypeManager) Keys() []string {
+    keys := make([]string, 0, len(m.prototypes))
+    for key := range m.prototypes {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+type cloneSettings struct {
+    name     string
+    naming   NamingPolicy
+    children []Inode
+    shared   bool
+}
+
+// CloneOverride adjusts a single clone made by the manager
+type CloneOverride func(*cloneSettings)
+
+// WithName renames the root of the clone
+func WithName(name string) CloneOverride {
+    return func(s *cloneSettings) { s.name = name }
+}
+
+// WithNaming renames every node of the clone; the default keeps the names
+func WithNaming(naming NamingPolicy) CloneOverride {
+    return func(s *cloneSettings) { s.naming = naming }
+}
+
+// PreserveSharing clones with CloneGraph instead of copying every path
+func PreserveSharing() CloneOverride {
+    return func(s *cloneSettings) { s.shared = true }
+}
+
+// WithChildren adds children to the root of the clone, which must be a folder
+func WithChildren(children ...Inode) CloneOverride {
+    return func(s *cloneSettings) { s.children = append(s.children, children...) }
+}
+
+func (m *PrototypeManager) Clone(key string, overrides ...CloneOverride) (Inode, error) {
+    prototype, ok := m.prototypes[key]
+    if !ok {
+        return nil, fmt.Errorf("no prototype registered as %q (registered: %s)", key, strings.Join(m.Keys(), ", "))
+    }
+    settings := cloneSettings{naming: KeepNames}
+    for _, override := range overrides {
+        override(&settings)
+    }
+    var clone Inode
+    if settings.shared {
+        clone = CloneGraph(prototype, settings.naming)
+    } else {
+        clone = prototype.cloneWith(settings.naming)
+    }
+    switch node := clone.(type) {
+    case *Folder:
+        if settings.name != "" {
+            node.name = settings.name
+        }
+        node.children = append(node.children, settings.children...)
+    case *File:
+        if settings.name != "" {
+            node.name = settings.name
+        }
+        if len(settings.children) > 0 {
+            return nil, errors.New("prototype " + key + " is a file and cannot take children")
+        }
+    }
+    return clone, nil
+}
+
+// Loading from disk
+// LoadTree reads dir from fsys into a Folder, keeping file contents and
+// permissions; entries that are neither files nor directories are skipped
+func LoadTree(fsys fs.FS, dir string) (*Folder, error) {
+    info, err := fs.Stat(fsys, dir)
+    if err != nil {
+        return nil, err
+    }
+    if !info.IsDir() {
+        return nil, fmt.Errorf("%s is not a directory", dir)
+    }
+    folder := &Folder{name: path.Base(dir), mode: info.Mode().Perm()}
+    entries, err := fs.ReadDir(fsys, dir)
+    if err != nil {
+        return nil, err
+    }
+    for _, entry := range entries {
+        name := path.Join(dir, entry.Name())
+        switch {
+        case entry.IsDir():
+            child, err := LoadTree(fsys, name)
+            if err != nil {
+                return nil, err
+            }
+            folder.children = append(folder.children, child)
+        case entry.Type().IsRegular():
+            info, err := entry.Info()
+            if err != nil {
+                return nil, err
+            }
+            content, err := fs.ReadFile(fsys, name)
+            if err != nil {
+                return nil, err
+            }
+            folder.children = append(folder.children, &File{name: entry.Name(), content: content, mode: info.Mode().Perm()})
+        }
+    }
+    return folder, nil
+}
+
+// Writing to disk
+type ConflictPolicy int
+
+const (
+    ConflictFail ConflictPolicy = iota
+    ConflictSkip
+    ConflictOverwrite
+)
+
+func ParseConflictPolicy(name string) (ConflictPolicy, error) {
+    switch name {
+    case "fail":
+        return ConflictFail, nil
+    case "skip":
+        return ConflictSkip, nil
+    case "overwrite":
+        return ConflictOverwrite, nil
+    }
+    return ConflictFail, fmt.Errorf("unknown conflict policy %q (want fail, skip or overwrite)", name)
+}
+
+type WriteOptions struct {
+    DryRun   bool
+    Conflict ConflictPolicy
+}
+
+// WriteSummary lists the paths WriteTree wrote, or would write in a dry run
+type WriteSummary struct {
+    Folders     []string
+    Created     []string
+    Overwritten []string
+    Skipped     []string
+}
+
+func (s WriteSummary) String() string {
+    return fmt.Sprintf("%d folder(s) created, %d file(s) created, %d overwritten, %d skipped",
+        len(s.Folders), len(s.Created), len(s.Overwritten), len(s.Skipped))
+}
+
+// WriteTree materialises node inside the target directory
+func WriteTree(node Inode, target string, options WriteOptions) (WriteSummary, error) {
+    var summary WriteSummary
+    err := writeNode(node, target, options, &summary)
+    return summary, err
+}
+
+// checkName accepts only names that are a single path element, so a node
+// cannot write outside the folder it belongs to
+func checkName(name string) error {
+    if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+        return fmt.Errorf("invalid name %q: must be a single path element", name)
+    }
+    return nil
+}
+
+func writeNode(node Inode, dir string, options WriteOptions, summary *WriteSummary) error {
+    if err := checkName(node.getName()); err != nil {
+        return fmt.Errorf("writing into %s: %w", dir, err)
+    }
+    target := filepath.Join(dir, node.getName())
+    existing, err := os.Lstat(target)
+    exists := err == nil
+    if err != nil && !errors.Is(err, fs.ErrNotExist) {
+        return err
+    }
+
+    switch node := node.(type) {
+    case *Folder:
+        if exists && !existing.IsDir() {
+            return fmt.Errorf("%s exists and is not a directory", target)
+        }
+        if !exists {
+            summary.Folders = append(summary.Folders, target)
+            if !options.DryRun {
+                if err := os.Mkdir(target, modeOr(node.mode, 0o755)); err != nil {
+                    return err
+                }
+            }
+        }
+        for _, child := range node.children {
+            if err := writeNode(child, target, options, summary); err != nil {
+                return err
+            }
+        }
+    case *File:
+        if exists {
+            switch {
+            case existing.IsDir():
+                return fmt.Errorf("%s exists and is a directory", target)
+            case options.Conflict == ConflictSkip:
+                summary.Skipped = append(summary.Skipped, target)
+                return nil
+            case options.Conflict == ConflictFail:
+                return fmt.Errorf("%s already exists", target)
+            }
+            summary.Overwritten = append(summary.Overwritten, target)
+        } else {
+            summary.Created = append(summary.Created, target)
+        }
+        if !options.DryRun {
+            if err := os.WriteFile(target, node.content, modeOr(node.mode, 0o644)); err != nil {
+                return err
+            }
+            if exists {
+                // WriteFile keeps the permissions of a file it truncates
+                return os.Chmod(target, modeOr(node.mode, 0o644))
+            }
+        }
+    }
+    return nil
+}
+
+func modeOr(mode fs.FileMode, fallback fs.FileMode) fs.FileMode {
+    if mode == 0 {
+        return fallback
+    }
+    return mode
+}
+
+// Diff and patch
+// DiffTrees compares the contents of two folders; the names of the roots
+// themselves are ignored, since a clone is usually renamed. A removed and an
+// added node with the same parent and identical contents count as a rename.
+// Cyclic graphs can be compared, but a cycle inside an added or modified node
+// cannot be written into a diff and is reported as an error
+type ChangeKind string
+
+const (
+    Added    ChangeKind = "added"
+    Removed  ChangeKind = "removed"
+    Renamed  ChangeKind = "renamed"
+    Modified ChangeKind = "modified"
+)
+
+type Change struct {
+    Kind    ChangeKind `json:"kind"`
+    Path    string     `json:"path"`
+    NewPath string     `json:"newPath,omitempty"`
+    Node    *NodeData  `json:"node,omitempty"`
+}
+
+// NodeData is the serialisable form of an added subtree or a modified file
+type NodeData struct {
+    Name     string      `json:"name"`
+    Folder   bool        `json:"folder,omitempty"`
+    Mode     fs.FileMode `json:"mode,omitempty"`
+    Content  []byte      `json:"content,omitempty"`
+    Children []*NodeData `json:"children,omitempty"`
+}
+
+type Diff struct {
+    Changes []Change `json:"changes"`
+}
+
+func toNodeData(node Inode, nodePath string, ancestors map[Inode]bool) (*NodeData, error) {
+    switch node := node.(type) {
+    case *Folder:
+        if ancestors[node] {
+            return nil, fmt.Errorf("%s: folder %s contains itself and cannot be stored in a diff", nodePath, node.name)
+        }
+        ancestors[node] = true
+        defer delete(ancestors, node)
+        data := &NodeData{Name: node.name, Folder: true, Mode: node.mode}
+        for _, child := range node.children {
+            childData, err := toNodeData(child, path.Join(nodePath, child.getName()), ancestors)
+            if err != nil {
+                return nil, err
+            }
+            data.Children = append(data.Children, childData)
+        }
+        return data, nil
+    case *File:
+        return &NodeData{Name: node.name, Mode: node.mode, Content: append([]byte(nil), node.content...)}, nil
+    }
+    return nil, nil
+}
+
+// check rejects node data that could not have come from a tree: missing
+// children and names that are not a single path element
+func (d *NodeData) check() error {
+    if d == nil {
+        return errors.New("missing node")
+    }
+    if err := checkName(d.Name); err != nil {
+        return err
+    }
+    if !d.Folder && len(d.Children) > 0 {
+        return fmt.Errorf("file %s has children", d.Name)
+    }
+    for _, child := range d.Children {
+        if err := child.check(); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (d *NodeData) inode() Inode {
+    if !d.Folder {
+        return &File{name: d.Name, content: append([]byte(nil), d.Content...), mode: d.Mode}
+    }
+    folder := &Folder{name: d.Name, mode: d.Mode}
+    for _, child := range d.Children {
+        folder.children = append(folder.children, child.inode())
+    }
+    return folder
+}
+
+// folderPair is an old and a new folder under comparison. Meeting a pair
+// again while it is still being compared means both graphs loop back the
+// same way, so there is nothing new to find below it
+type folderPair struct {
+    old *Folder
+    new *Folder
+}
+
+func DiffTrees(old *Folder, new *Folder) (Diff, error) {
+    var diff Diff
+    if err := diffFolders(old, new, "", &diff, map[folderPair]bool{}); err != nil {
+        return Diff{}, err
+    }
+    sort.SliceStable(diff.Changes, func(i, j int) bool {
+        return diff.Changes[i].Path < diff.Changes[j].Path
+    })
+    return diff, nil
+}
+
+func diffFolders(old *Folder, new *Folder, dir string, diff *Diff, comparing map[folderPair]bool) error {
+    pair := folderPair{old, new}
+    if comparing[pair] {
+        return nil
+    }
+    comparing[pair] = true
+    defer delete(comparing, pair)
+
+    newChildren := map[string]Inode{}
+    for _, child := range new.children {
+        newChildren[child.getName()] = child
+    }
+    var removed []Inode
+    seen := map[string]bool{}
+    for _, oldChild := range old.children {
+        name := oldChild.getName()
+        newChild, ok := newChildren[name]
+        seen[name] = true
+        switch {
+        case !ok:
+            removed = append(removed, oldChild)
+        case isFolder(oldChild) != isFolder(newChild):
+            removed = append(removed, oldChild)
+            seen[name] = false
+        case isFolder(oldChild):
+            if err := diffFolders(oldChild.(*Folder), newChild.(*Folder), path.Join(dir, name), diff, comparing); err != nil {
+                return err
+            }
+        case !sameContents(oldChild, newChild, comparing):
+            node, err := toNodeData(newChild, path.Join(dir, name), map[Inode]bool{})
+            if err != nil {
+                return err
+            }
+            diff.Changes = append(diff.Changes, Change{Kind: Modified, Path: path.Join(dir, name), Node: node})
+        }
+    }
+    var added []Inode
+    for _, newChild := range new.children {
+        if !seen[newChild.getName()] {
+            added = append(added, newChild)
+        }
+    }
+
+    for _, oldChild := range removed {
+        oldPath := path.Join(dir, oldChild.getName())
+        match := -1
+        for i, newChild := range added {
+            if newChild != nil && sameContents(oldChild, newChild, comparing) {
+                match = i
+                break
+            }
+        }
+        if match < 0 {
+            diff.Changes = append(diff.Changes, Change{Kind: Removed, Path: oldPath})
+            continue
+        }
+        diff.Changes = append(diff.Changes, Change{Kind: Renamed, Path: oldPath, NewPath: path.Join(dir, added[match].getName())})
+        added[match] = nil
+    }
+    for _, newChild := range added {
+        if newChild != nil {
+            newPath := path.Join(dir, newChild.getName())
+            node, err := toNodeData(newChild, newPath, map[Inode]bool{})
+            if err != nil {
+                return err
+            }
+            diff.Changes = append(diff.Changes, Change{Kind: Added, Path: newPath, Node: node})
+        }
+    }
+    return nil
+}
+
+func isFolder(node Inode) bool {
+    _, ok := node.(*Folder)
+    return ok
+}
+
+// sameContents compares two nodes ignoring their own names; a pair of folders
+// met again while comparing them is assumed equal, which ends cycles
+func sameContents(a Inode, b Inode, comparing map[folderPair]bool) bool {
+    switch a := a.(type) {
+    case *File:
+        b, ok := b.(*File)
+        return ok && a.mode == b.mode && string(a.content) == string(b.content)
+    case *Folder:
+        b, ok := b.(*Folder)
+        if !ok || a.mode != b.mode || len(a.children) != len(b.children) {
+            return false
+        }
+        pair := folderPair{a, b}
+        if comparing[pair] {
+            return true
+        }
+        comparing[pair] = true
+        defer delete(comparing, pair)
+        byName := map[string]Inode{}
+        for _, child := range b.children {
+            byName[child.getName()] = child
+        }
+        for _, child := range a.children {
+            other, ok := byName[child.getName()]
+            if !ok || !sameContents(child, other, comparing) {
+                return false
+            }
+        }
+        return true
+    }
+    return false
+}
+
+func (d Diff) Empty() bool {
+    return len(d.Changes) == 0
+}
+
+// String renders the changes as a tree: + added, - removed, ~ modified, > renamed
+func (d Diff) String() string {
+    var out strings.Builder
+    printed := map[string]bool{}
+    markers := map[ChangeKind]string{Added: "+ ", Removed: "- ", Modified: "~ ", Renamed: "> "}
+    for _, change := range d.Changes {
+        parts := strings.Split(change.Path, "/")
+        for depth := range parts[:len(parts)-1] {
+            dir := strings.Join(parts[:depth+1], "/")
+            if !printed[dir] {
+                printed[dir] = true
+                out.WriteString(strings.Repeat("    ", depth) + "  " + parts[depth] + "/\n")
+            }
+        }
+        line := strings.Repeat("    ", len(parts)-1) + markers[change.Kind] + parts[len(parts)-1]
+        if change.Node != nil && change.Node.Folder {
+            line += "/"
+        }
+        if change.Kind == Renamed {
+            line += " -> " + path.Base(change.NewPath)
+        }
+        out.WriteString(line + "\n")
+    }
+    return out.String()
+}
+
+// Apply patches root in place. Every change is attempted; the ones that do not
+// fit the tree, e.g. removing a file that is already gone, are reported together
+func (d Diff) Apply(root *Folder) error {
+    order := map[ChangeKind]int{Removed: 0, Renamed: 1, Modified: 2, Added: 3}
+    changes := append([]Change(nil), d.Changes...)
+    sort.SliceStable(changes, func(i, j int) bool {
+        return order[changes[i].Kind] < order[changes[j].Kind]
+    })
+    var errs []error
+    for _, change := range changes {
+        if err := applyChange(root, change); err != nil {
+            errs = append(errs, fmt.Errorf("%s %s: %w", change.Kind, change.Path, err))
+        }
+    }
+    return errors.Join(errs...)
+}
+
+func applyChange(root *Folder, change Change) error {
+    if !fs.ValidPath(change.Path) || change.Path == "." {
+        return errors.New("invalid path")
+    }
+    name := path.Base(change.Path)
+    if err := checkName(name); err != nil {
+        return err
+    }
+    switch change.Kind {
+    case Added, Modified:
+        if err := change.Node.check(); err != nil {
+            return err
+        }
+        if change.Node.Name != name {
+            return fmt.Errorf("node is named %q, not %q", change.Node.Name, name)
+        }
+        if change.Kind == Modified && change.Node.Folder {
+            return errors.New("only files can be modified")
+        }
+    case Renamed:
+        if path.Dir(change.NewPath) != path.Dir(change.Path) {
+            return errors.New("can only rename within a folder")
+        }
+        if err := checkName(path.Base(change.NewPath)); err != nil {
+            return err
+        }
+    }
+    parent, err := findFolder(root, path.Dir(change.Path))
+    if err != nil {
+        return err
+    }
+    index := -1
+    for i, child := range parent.children {
+        if child.getName() == name {
+            index = i
+        }
+    }
+    if change.Kind == Added {
+        if index >= 0 {
+            return errors.New("already exists")
+        }
+        parent.children = append(parent.children, change.Node.inode())
+        return nil
+    }
+    if index < 0 {
+        return errors.New("not found")
+    }
+    switch change.Kind {
+    case Removed:
+        parent.children = append(parent.children[:index], parent.children[index+1:]...)
+    case Renamed:
+        newName := path.Base(change.NewPath)
+        for _, child := range parent.children {
+            if child.getName() == newName {
+                return errors.New(newName + " already exists")
+            }
+        }
+        switch node := parent.children[index].(type) {
+        case *File:
+            node.name = newName
+        case *Folder:
+            node.name = newName
+        }
+    case Modified:
+        file, ok := parent.children[index].(*File)
+        if !ok {
+            return errors.New("is not a file")
+        }
+        file.content = append([]byte(nil), change.Node.Content...)
+        file.mode = change.Node.Mode
+    }
+    return nil
+}
+
+func findFolder(root *Folder, dir string) (*Folder, error) {
+    folder := root
+    if dir == "." {
+        return folder, nil
+    }
+    for _, name := range strings.Split(dir, "/") {
+        var next *Folder
+        for _, child := range folder.children {
+            if child, ok := child.(*Folder); ok && child.name == name {
+                next = child
+            }
+        }
+        if next == nil {
+            return nil, errors.New("folder " + dir + " not found")
+        }
+        folder = next
+    }
+    return folder, nil
+}
+
+// Inode trees as an fs.FS
+// TreeFS serves a folder through io/fs, so fs.WalkDir, fs.Glob and
+// http.FileServer(http.FS(...)) work on it. As in the renderers, a folder
+// that contains one of its ancestors does not list or open that ancestor,
+// so walking a cyclic graph ends
+type TreeFS struct {
+    root *Folder
+}
+
+func NewTreeFS(root *Folder) TreeFS {
+    return TreeFS{root: root}
+}
+
+func (t TreeFS) Open(name string) (fs.File, error) {
+    if !fs.ValidPath(name) {
+        return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+    }
+    var node Inode = t.root
+    ancestors := map[Inode]bool{t.root: true}
+    if name != "." {
+        for _, part := range strings.Split(name, "/") {
+            folder, ok := node.(*Folder)
+            if !ok {
+                return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+            }
+            node = nil
+            for _, child := range folder.children {
+                if child.getName() == part {
+                    node = child
+                    break
+                }
+            }
+            if node == nil || ancestors[node] {
+                return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+            }
+            ancestors[node] = true
+        }
+    }
+    switch node := node.(type) {
+    case *Folder:
+        return &openFolder{folder: node, ancestors: ancestors}, nil
+    case *File:
+        return &openFile{file: node, reader: bytes.NewReader(node.content)}, nil
+    }
+    return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+type inodeInfo struct {
+    node Inode
+}
+
+func (i inodeInfo) Name() string { return i.node.getName() }
+func (i inodeInfo) Size() int64 {
+    if file, ok := i.node.(*File); ok {
+        return int64(len(file.content))
+    }
+    return 0
+}
+func (i inodeInfo) Mode() fs.FileMode {
+    switch node := i.node.(type) {
+    case *Folder:
+        return fs.ModeDir | modeOr(node.mode, 0o755)
+    case *File:
+        return modeOr(node.mode, 0o644)
+    }
+    return 0
+}
+func (i inodeInfo) ModTime() time.Time { return time.Time{} }
+func (i inodeInfo) IsDir() bool       { return i.Mode().IsDir() }
+func (i inodeInfo) Sys() any          { return nil }
+
+type openFile struct {
+    file   *File
+    reader *bytes.Reader
+}
+
+func (f *openFile) Stat() (fs.FileInfo, error) { return inodeInfo{f.file}, nil }
+func (f *openFile) Read(p []byte) (int, error) { return f.reader.Read(p) }
+func (f *openFile) Seek(offset int64, whence int) (int64, error) {
+    return f.reader.Seek(offset, whence)
+}
+func (f *openFile) Close() error { return nil }
+
+type openFolder struct {
+    folder    *Folder
+    ancestors map[Inode]bool
+    entries   []fs.DirEntry
+    read      bool
+}
+
+func (f *openFolder) Stat() (fs.FileInfo, error) { return inodeInfo{f.folder}, nil }
+func (f *openFolder) Read(p []byte) (int, error) {
+    return 0, &fs.PathError{Op: "read", Path: f.folder.name, Err: errors.New("is a directory")}
+}
+func (f *openFolder) Close() error { return nil }
+
+func (f *openFolder) ReadDir(n int) ([]fs.DirEntry, error) {
+    if !f.read {
+        f.read = true
+        for _, child := range f.folder.children {
+            if f.ancestors[child] {
+                continue
+            }
+            f.entries = append(f.entries, fs.FileInfoToDirEntry(inodeInfo{child}))
+        }
+        sort.Slice(f.entries, func(i, j int) bool { return f.entries[i].Name() < f.entries[j].Name() })
+    }
+    if n <= 0 {
+        entries := f.entries
+        f.entries = nil
+        return entries, nil
+    }
+    if len(f.entries) == 0 {
+        return nil, io.EOF
+    }
+    n = min(n, len(f.entries))
+    entries := f.entries[:n]
+    f.entries = f.entries[n:]
+    return entries, nil
+}
+
+// Renderers
+// Every renderer indents by depth and stops at MaxDepth when it is set.
+// A folder that contains one of its ancestors is marked instead of followed
+type Renderer interface {
+    Render(w io.Writer, root Inode) error
+}
+
+// TreeRenderer draws the tree like the `tree` command
+type TreeRenderer struct {
+    MaxDepth int
+}
+
+func (r TreeRenderer) Render(w io.Writer, root Inode) error {
+    out := bufio.NewWriter(w)
+    fmt.Fprintln(out, root.getName())
+    r.children(out, root, "", 1, map[Inode]bool{root: true})
+    return out.Flush()
+}
+
+func (r TreeRenderer) children(out *bufio.Writer, node Inode, prefix string, depth int, ancestors map[Inode]bool) {
+    folder, ok := node.(*Folder)
+    if !ok || (r.MaxDepth > 0 && depth > r.MaxDepth) {
+        return
+    }
+    for i, child := range folder.children {
+        branch, indent := "├── ", "│   "
+        if i == len(folder.children)-1 {
+            branch, indent = "└── ", "    "
+        }
+        if ancestors[child] {
+            fmt.Fprintln(out, prefix+branch+child.getName()+" (cycle)")
+            continue
+        }
+        fmt.Fprintln(out, prefix+branch+child.getName())
+        ancestors[child] = true
+        r.children(out, child, prefix+indent, depth+1, ancestors)
+        delete(ancestors, child)
+    }
+}
+
+// ListRenderer prints one name per line, indented by Indent per level
+type ListRenderer struct {
+    Indent   string
+    MaxDepth int
+}
+
+func (r ListRenderer) Render(w io.Writer, root Inode) error {
+    out := bufio.NewWriter(w)
+    r.node(out, root, 0, map[Inode]bool{})
+    return out.Flush()
+}
+
+func (r ListRenderer) node(out *bufio.Writer, node Inode, depth int, ancestors map[Inode]bool) {
+    indent := strings.Repeat(r.Indent, depth)
+    if ancestors[node] {
+        fmt.Fprintln(out, indent+node.getName()+" (cycle)")
+        return
+    }
+    fmt.Fprintln(out, indent+node.getName())
+    folder, ok := node.(*Folder)
+    if !ok || (r.MaxDepth > 0 && depth >= r.MaxDepth) {
+        return
+    }
+    ancestors[node] = true
+    for _, child := range folder.children {
+        r.node(out, child, depth+1, ancestors)
+    }
+    delete(ancestors, node)
+}
+
+// JSONRenderer writes the structure, not the contents, as indented JSON
+type JSONRenderer struct {
+    MaxDepth int
+}
+
+type renderedNode struct {
+    Name     string          `json:"name"`
+    Type     string          `json:"type"`
+    Size     int64           `json:"size,omitempty"`
+    Cycle    bool            `json:"cycle,omitempty"`
+    Children []*renderedNode `json:"children,omitempty"`
+}
+
+func (r JSONRenderer) Render(w io.Writer, root Inode) error {
+    encoder := json.NewEncoder(w)
+    encoder.SetIndent("", "  ")
+    return encoder.Encode(r.node(root, 0, map[Inode]bool{}))
+}
+
+func (r JSONRenderer) node(node Inode, depth int, ancestors map[Inode]bool) *renderedNode {
+    info := inodeInfo{node}
+    rendered := &renderedNode{Name: node.getName(), Type: "file", Size: info.Size()}
+    folder, ok := node.(*Folder)
+    if !ok {
+        return rendered
+    }
+    rendered.Type = "folder"
+    if ancestors[node] {
+        rendered.Cycle = true
+        return rendered
+    }
+    if r.MaxDepth > 0 && depth >= r.MaxDepth {
+        return rendered
+    }
+    ancestors[node] = true
+    for _, child := range folder.children {
+        rendered.Children = append(rendered.Children, r.node(child, depth+1, ancestors))
+    }
+    delete(ancestors, node)
+    return rendered
+}
+
+// scaffold clones the template at from into to, e.g.
+// go run Prototype.go -from ./templates/service -to ./projects -name billing
+func scaffold(from string, to string, name string, dryRun bool, conflict string) error {
+    policy, err := ParseConflictPolicy(conflict)
+    if err != nil {
+        return err
+    }
+    template, err := LoadTree(os.DirFS(from), ".")
+    if err != nil {
+        return err
+    }
+    // name the template after the directory itself, also for from="."
+    absolute, err := filepath.Abs(from)
+    if err != nil {
+        return err
+    }
+    template.name = filepath.Base(absolute)
+    manager := NewPrototypeManager()
+    manager.Register("template", template)
+    var overrides []CloneOverride
+    if name != "" {
+        overrides = append(overrides, WithName(name))
+    }
+    project, err := manager.Clone("template", overrides...)
+    if err != nil {
+        return err
+    }
+    summary, err := WriteTree(project, to, WriteOptions{DryRun: dryRun, Conflict: policy})
+    for _, created := range append(summary.Folders, summary.Created...) {
+        fmt.Println("create    " + created)
+    }
+    for _, overwritten := range summary.Overwritten {
+        fmt.Println("overwrite " + overwritten)
+    }
+    for _, skipped := range summary.Skipped {
+        fmt.Println("skip      " + skipped)
+    }
+    fmt.Println(summary)
+    return err
+}
+
 // client
 func main() {
+    from := flag.String("from", "", "template directory to scaffold from")
+    to := flag.String("to", ".", "directory to write the new project into")
+    name := flag.String("name", "", "name of the new project (defaults to the template's)")
+    dryRun := flag.Bool("dry-run", false, "report what would be written without writing")
+    conflict := flag.String("conflict", "fail", "what to do with existing files: fail, skip or overwrite")
+    flag.Parse()
+    if *from != "" {
+        if err := scaffold(*from, *to, *name, *dryRun, *conflict); err != nil {
+            fmt.Fprintln(os.Stderr, err)
+            os.Exit(1)
+        }
+        return
+    }
+
     file1 := &File{name: "File1"}
     file2 := &File{name: "File2"}
     file3 := &File{name: "File3"}
@@ -66,4 +1044,98 @@ func main() {
     cloneFolder := folder2.clone()
     fmt.Println("\nPrinting hierarchy for clone Folder")
     cloneFolder.print("  ")
+
+    manager := NewPrototypeManager()
+    manager.Register("go-service", &Folder{
+        name: "service",
+        children: []Inode{
+            &File{name: "go.mod", content: []byte("module service\n")},
+            &File{name: "main.go", content: []byte("package main\n\nfunc main() {}\n")},
+            &Folder{name: "internal", children: []Inode{&File{name: "doc.go", content: []byte("package internal\n")}}},
+        },
+    })
+
+    project, _ := manager.Clone("go-service",
+        WithName("billing"),
+        WithChildren(&File{name: "README.md"}),
+    )
+    fmt.Println("\nPrinting hierarchy for a project cloned from the go-service template")
+    project.print("  ")
+
+    upper, _ := manager.Clone("go-service", WithNaming(strings.ToUpper))
+    fmt.Println("\nPrinting hierarchy for a clone with a custom naming policy")
+    upper.print("  ")
+
+    if _, err := manager.Clone("rust-service"); err != nil {
+        fmt.Println("\n" + err.Error())
+    }
+
+    target, err := os.MkdirTemp("", "prototype")
+    if err != nil {
+        fmt.Println(err)
+        return
+    }
+    defer os.RemoveAll(target)
+    fmt.Println("\nWriting the billing project to disk")
+    summary, _ := WriteTree(project, target, WriteOptions{DryRun: true})
+    fmt.Println("  dry run: " + summary.String())
+    summary, _ = WriteTree(project, target, WriteOptions{})
+    fmt.Println("  first write: " + summary.String())
+    summary, _ = WriteTree(project, target, WriteOptions{Conflict: ConflictSkip})
+    fmt.Println("  second write, skipping conflicts: " + summary.String())
+    if _, err := WriteTree(project, target, WriteOptions{Conflict: ConflictFail}); err != nil {
+        fmt.Println("  third write, failing on conflicts: " + strings.ReplaceAll(err.Error(), target, "$TMP"))
+    }
+
+    loaded, err := LoadTree(os.DirFS(target), "billing")
+    if err != nil {
+        fmt.Println(err)
+        return
+    }
+    fmt.Println("\nPrinting hierarchy loaded back from disk")
+    loaded.print("  ")
+
+    fmt.Println("\nDiffing an edited clone against its template")
+    template, _ := manager.Clone("go-service")
+    edited, _ := manager.Clone("go-service")
+    editedFolder := edited.(*Folder)
+    editedFolder.children[1].(*File).content = []byte("package main\n\nfunc main() { run() }\n")
+    editedFolder.children[0].(*File).name = "go.module"
+    editedFolder.children[2].(*Folder).children = []Inode{&File{name: "api.go", content: []byte("package internal\n\ntype API struct{}\n")}}
+    editedFolder.children = append(editedFolder.children, &Folder{name: "cmd", children: []Inode{&File{name: "run.go"}}})
+    diff, err := DiffTrees(template.(*Folder), editedFolder)
+    if err != nil {
+        fmt.Println(err)
+        return
+    }
+    fmt.Print(diff)
+    encoded, _ := json.Marshal(diff)
+    fmt.Println(string(encoded))
+
+    fmt.Println("\nPatching a project cloned earlier from the same template")
+    if err := diff.Apply(project.(*Folder)); err != nil {
+        fmt.Println(err)
+    }
+    project.print("  ")
+    fmt.Println("Patching it twice reports what no longer fits:")
+    fmt.Println(diff.Apply(project.(*Folder)))
+
+    fmt.Println("\nRendering the patched project")
+    TreeRenderer{}.Render(os.Stdout, project)
+    ListRenderer{Indent: "  - ", MaxDepth: 1}.Render(os.Stdout, project)
+    JSONRenderer{MaxDepth: 1}.Render(os.Stdout, project)
+
+    fmt.Println("\nBrowsing the patched project through io/fs")
+    projectFS := NewTreeFS(project.(*Folder))
+    fs.WalkDir(projectFS, ".", func(name string, entry fs.DirEntry, err error) error {
+        if err == nil {
+            fmt.Println("  walk " + name)
+        }
+        return err
+    })
+    matches, _ := fs.Glob(projectFS, "*/*.go")
+    fmt.Println("  glob */*.go: " + strings.Join(matches, ", "))
+    response := httptest.NewRecorder()
+    http.FileServer(http.FS(projectFS)).ServeHTTP(response, httptest.NewRequest("GET", "/main.go", nil))
+    fmt.Printf("  GET /main.go: %d %q\n", response.Code, response.Body.String())
 }
\ No newline at end of file
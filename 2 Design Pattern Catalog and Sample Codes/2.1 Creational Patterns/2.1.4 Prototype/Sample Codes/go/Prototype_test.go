@@ -0,0 +1,174 @@
+package main
+
+import (
+    "io/fs"
+    "os"
+    "path/filepath"
+    "testing"
+    "testing/fstest"
+)
+
+func TestCloneGraphKeepsSharedNodes(t *testing.T) {
+    shared := &File{name: "shared.txt"}
+    left := &Folder{name: "left", children: []Inode{shared}}
+    right := &Folder{name: "right", children: []Inode{shared}}
+    dag := &Folder{name: "dag", children: []Inode{left, right}}
+    dagClone := CloneGraph(dag, CloneSuffix).(*Folder)
+    leftClone := dagClone.children[0].(*Folder)
+    rightClone := dagClone.children[1].(*Folder)
+    if leftClone.children[0] != rightClone.children[0] {
+        t.Error("a file shared by two folders was cloned twice")
+    }
+    if leftClone.children[0] == Inode(shared) {
+        t.Error("the shared clone is the original file")
+    }
+    if got := leftClone.children[0].getName(); got != "shared.txt_clone" {
+        t.Errorf("shared clone is named %q, want shared.txt_clone", got)
+    }
+    treeClone := dag.cloneWith(KeepNames).(*Folder)
+    if treeClone.children[0].(*Folder).children[0] == treeClone.children[1].(*Folder).children[0] {
+        t.Error("cloneWith shared a node instead of copying every path")
+    }
+}
+
+// cyclicTree returns loop -> inner -> loop, with a file next to inner
+func cyclicTree() *Folder {
+    loop := &Folder{name: "loop"}
+    inner := &Folder{name: "inner", children: []Inode{loop}}
+    loop.children = []Inode{inner, &File{name: "file", content: []byte("data")}}
+    return loop
+}
+
+func TestCloneGraphCycles(t *testing.T) {
+    loop := cyclicTree()
+    inner := loop.children[0].(*Folder)
+    loopClone := CloneGraph(loop, KeepNames).(*Folder)
+    innerClone := loopClone.children[0].(*Folder)
+    if innerClone.children[0] != Inode(loopClone) {
+        t.Error("a folder reachable from itself does not point back to its clone")
+    }
+    if innerClone == inner || loopClone == loop {
+        t.Error("the cycle was reused instead of cloned")
+    }
+
+    self := &Folder{name: "self"}
+    self.children = []Inode{self}
+    selfClone := CloneGraph(self, KeepNames).(*Folder)
+    if selfClone.children[0] != Inode(selfClone) {
+        t.Error("a folder that contains itself was not cloned onto itself")
+    }
+}
+
+func TestManagerPreservesSharing(t *testing.T) {
+    manager := NewPrototypeManager()
+    manager.Register("loop", cyclicTree())
+    managed, err := manager.Clone("loop", PreserveSharing(), WithName("copy"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if managed.(*Folder).children[0].(*Folder).children[0] != managed {
+        t.Error("the manager did not clone the cyclic template with its cycle")
+    }
+}
+
+func TestDiffCyclicClone(t *testing.T) {
+    template := cyclicTree()
+    clone := CloneGraph(template, KeepNames).(*Folder)
+    diff, err := DiffTrees(template, clone)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !diff.Empty() {
+        t.Errorf("an unchanged cyclic clone differs:\n%s", diff)
+    }
+
+    clone.children[1].(*File).content = []byte("edited")
+    diff, err = DiffTrees(template, clone)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(diff.Changes) != 1 || diff.Changes[0].Kind != Modified || diff.Changes[0].Path != "file" {
+        t.Errorf("want one modification of file, got:\n%s", diff)
+    }
+}
+
+func TestDiffRejectsAddedCycle(t *testing.T) {
+    old := &Folder{name: "root"}
+    new := &Folder{name: "root", children: []Inode{cyclicTree()}}
+    if _, err := DiffTrees(old, new); err == nil {
+        t.Error("an added folder that contains itself was written into a diff")
+    }
+}
+
+func TestTreeFS(t *testing.T) {
+    project := &Folder{name: "project", children: []Inode{
+        &File{name: "main.go", content: []byte("package main\n")},
+        &Folder{name: "internal", children: []Inode{&File{name: "api.go"}}},
+    }}
+    if err := fstest.TestFS(NewTreeFS(project), "main.go", "internal/api.go"); err != nil {
+        t.Error(err)
+    }
+}
+
+func TestTreeFSCycles(t *testing.T) {
+    treeFS := NewTreeFS(cyclicTree())
+    var walked []string
+    err := fs.WalkDir(treeFS, ".", func(name string, entry fs.DirEntry, err error) error {
+        if len(walked) > 10 {
+            return fs.SkipAll
+        }
+        walked = append(walked, name)
+        return err
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(walked) != 3 {
+        t.Errorf("walked %v, want ., file and inner only", walked)
+    }
+    if _, err := treeFS.Open("inner/loop"); err == nil {
+        t.Error("opened a folder through its own descendant")
+    }
+    if err := fstest.TestFS(treeFS, "file", "inner"); err != nil {
+        t.Error(err)
+    }
+}
+
+func TestWriteTreeRejectsPathNames(t *testing.T) {
+    for _, name := range []string{"", ".", "..", "../escape", "a/b", `a\b`} {
+        target := t.TempDir()
+        tree := &Folder{name: "project", children: []Inode{&File{name: name, content: []byte("x")}}}
+        if _, err := WriteTree(tree, target, WriteOptions{}); err == nil {
+            t.Errorf("WriteTree wrote a file named %q", name)
+        }
+        if _, err := os.Stat(filepath.Join(target, "escape")); err == nil {
+            t.Errorf("a file named %q escaped into the target directory", name)
+        }
+    }
+}
+
+func TestApplyRejectsMalformedChanges(t *testing.T) {
+    file := func(name string) *NodeData { return &NodeData{Name: name, Content: []byte("x")} }
+    changes := []Change{
+        {Kind: Added, Path: "new.go"},
+        {Kind: Modified, Path: "main.go"},
+        {Kind: Added, Path: "new.go", Node: file("other.go")},
+        {Kind: Added, Path: "new.go", Node: file("../new.go")},
+        {Kind: Added, Path: "../new.go", Node: file("new.go")},
+        {Kind: Added, Path: "a/../new.go", Node: file("new.go")},
+        {Kind: Added, Path: "dir", Node: &NodeData{Name: "dir", Folder: true, Children: []*NodeData{file("a/b")}}},
+        {Kind: Added, Path: "dir", Node: &NodeData{Name: "dir", Folder: true, Children: []*NodeData{nil}}},
+        {Kind: Modified, Path: "internal", Node: &NodeData{Name: "internal", Folder: true}},
+        {Kind: Renamed, Path: "main.go", NewPath: "internal/main.go"},
+        {Kind: Renamed, Path: "main.go", NewPath: ".."},
+    }
+    for _, change := range changes {
+        root := &Folder{name: "project", children: []Inode{
+            &File{name: "main.go"},
+            &Folder{name: "internal"},
+        }}
+        if err := (Diff{Changes: []Change{change}}).Apply(root); err == nil {
+            t.Errorf("applied %+v", change)
+        }
+    }
+}
@@ -1,8 +1,16 @@
 package main
 
 import (
+    "context"
+    "errors"
     "fmt"
+    "io"
+    "os"
+    "reflect"
+    "sort"
     "sync"
+    "sync/atomic"
+    "time"
 )
 
 var lock = &sync.Mutex{}
@@ -10,28 +18,524 @@ var lock = &sync.Mutex{}
 type single struct {
 }
 
-var singleInstance *single
+// singleInstance is read without the lock on the fast path, so it has to be
+// an atomic pointer; a plain pointer there is a data race
+var singleInstance atomic.Pointer[single]
+
+// output is where getInstance reports what it did; tests silence it
+var output io.Writer = os.Stdout
 
 func getInstance() *single {
-    if singleInstance == nil {
-        lock.Lock()
-        defer lock.Unlock()
-        if singleInstance == nil {
-            fmt.Println("Creating single instance now.")
-            singleInstance = &single{}
-        } else {
-            fmt.Println("Single instance already created.")
+    if instance := singleInstance.Load(); instance != nil {
+        fmt.Fprintln(output, "Single instance already created.")
+        return instance
+    }
+    lock.Lock()
+    defer lock.Unlock()
+    if instance := singleInstance.Load(); instance != nil {
+        fmt.Fprintln(output, "Single instance already created.")
+        return instance
+    }
+    fmt.Fprintln(output, "Creating single instance now.")
+    instance := &single{}
+    singleInstance.Store(instance)
+    return instance
+}
+
+// resetInstance forgets the package-level instance so tests start clean
+func resetInstance() {
+    lock.Lock()
+    defer lock.Unlock()
+    singleInstance.Store(nil)
+}
+
+// Fast path variants
+// Three race-free ways to write getInstance, compared in Singleton_test.go
+type singletonVariant interface {
+    get() *single
+}
+
+// mutexSingleton takes the lock on every call
+type mutexSingleton struct {
+    mu        sync.Mutex
+    instance  *single
+    construct func() *single
+}
+
+func (s *mutexSingleton) get() *single {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.instance == nil {
+        s.instance = s.construct()
+    }
+    return s.instance
+}
+
+// onceSingleton lets sync.Once do the double-checking
+type onceSingleton struct {
+    once      sync.Once
+    instance  *single
+    construct func() *single
+}
+
+func (s *onceSingleton) get() *single {
+    s.once.Do(func() { s.instance = s.construct() })
+    return s.instance
+}
+
+// atomicSingleton is double-checked locking over an atomic pointer, like getInstance
+type atomicSingleton struct {
+    mu        sync.Mutex
+    instance  atomic.Pointer[single]
+    construct func() *single
+}
+
+func (s *atomicSingleton) get() *single {
+    if instance := s.instance.Load(); instance != nil {
+        return instance
+    }
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if instance := s.instance.Load(); instance != nil {
+        return instance
+    }
+    instance := s.construct()
+    s.instance.Store(instance)
+    return instance
+}
+
+// lazySingleton runs the generic Lazy through the same comparisons
+type lazySingleton struct {
+    lazy *Lazy[*single]
+}
+
+func (s lazySingleton) get() *single {
+    instance, _ := s.lazy.Get()
+    return instance
+}
+
+// RetryPolicy decides what Lazy does after its constructor fails
+type RetryPolicy int
+
+const (
+    // RetryOnNextCall runs the constructor again on the next Get
+    RetryOnNextCall RetryPolicy = iota
+    // FailPermanently remembers the first error and returns it forever
+    FailPermanently
+)
+
+// Lazy is a reusable lazily created singleton whose constructor may fail
+type Lazy[T any] struct {
+    construct func() (T, error)
+    policy    RetryPolicy
+    mu        sync.Mutex
+    done      atomic.Bool
+    value     T
+    err       error
+    attempts  int
+}
+
+func NewLazy[T any](construct func() (T, error), policy RetryPolicy) *Lazy[T] {
+    return &Lazy[T]{construct: construct, policy: policy}
+}
+
+// Get returns the instance, running the constructor at most once at a time
+func (l *Lazy[T]) Get() (T, error) {
+    if l.done.Load() {
+        return l.value, l.err
+    }
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    if l.done.Load() {
+        return l.value, l.err
+    }
+    l.attempts++
+    value, err := l.call()
+    if err != nil && l.policy == RetryOnNextCall {
+        var zero T
+        return zero, err
+    }
+    l.value, l.err = value, err
+    l.done.Store(true)
+    return value, err
+}
+
+// call turns a panicking constructor into an error
+func (l *Lazy[T]) call() (value T, err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            err = fmt.Errorf("lazy: constructor panicked: %v", r)
         }
-    } else {
-        fmt.Println("Single instance already created.")
+    }()
+    return l.construct()
+}
+
+// Initialized reports whether an instance has been created successfully
+func (l *Lazy[T]) Initialized() bool {
+    if !l.done.Load() {
+        return false
+    }
+    return l.err == nil
+}
+
+// Attempts reports how many times the constructor has run
+func (l *Lazy[T]) Attempts() int {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    return l.attempts
+}
+
+// Scoped singletons
+// A Scope holds at most one instance per type, so a tenant, a request context
+// or a test can each have their own. Closing a scope runs its close hooks,
+// including Close on instances that are io.Closers, newest first
+type Scope struct {
+    name      string
+    mu        sync.Mutex
+    instances map[reflect.Type]any
+    hooks     []func() error
+    closed    bool
+}
+
+func NewScope(name string) *Scope {
+    return &Scope{name: name, instances: map[reflect.Type]any{}}
+}
+
+func (s *Scope) Name() string {
+    return s.name
+}
+
+// OnClose registers a hook to run when the scope closes. A hook registered
+// once the scope is closed runs straight away
+func (s *Scope) OnClose(hook func() error) error {
+    s.mu.Lock()
+    if s.closed {
+        s.mu.Unlock()
+        return hook()
+    }
+    s.hooks = append(s.hooks, hook)
+    s.mu.Unlock()
+    return nil
+}
+
+// Close runs the hooks in reverse order of registration. A closed scope
+// stays closed; Scopes.Reset hands out a fresh scope for the key instead
+func (s *Scope) Close() error {
+    s.mu.Lock()
+    if s.closed {
+        s.mu.Unlock()
+        return nil
+    }
+    s.closed = true
+    hooks := s.hooks
+    s.hooks = nil
+    s.instances = nil
+    s.mu.Unlock()
+    var errs []error
+    for i := len(hooks) - 1; i >= 0; i-- {
+        errs = append(errs, hooks[i]())
     }
+    return errors.Join(errs...)
+}
+
+// Scoped returns the scope's instance of T, constructing it on first use.
+// A failed construction is retried on the next call; a closed scope fails
+func Scoped[T any](scope *Scope, construct func() (T, error)) (T, error) {
+    var zero T
+    kind := reflect.TypeFor[T]()
+    scope.mu.Lock()
+    if scope.closed {
+        scope.mu.Unlock()
+        return zero, fmt.Errorf("scope %s is closed", scope.name)
+    }
+    lazy, ok := scope.instances[kind].(*Lazy[T])
+    if !ok {
+        lazy = NewLazy(func() (T, error) {
+            value, err := construct()
+            if err != nil {
+                return zero, err
+            }
+            if closer, ok := any(value).(io.Closer); ok {
+                err = scope.OnClose(closer.Close)
+            }
+            // the scope may have closed while value was being built, in which
+            // case OnClose has closed it already
+            if scope.isClosed() {
+                return zero, errors.Join(fmt.Errorf("scope %s closed while constructing %v", scope.name, kind), err)
+            }
+            return value, nil
+        }, RetryOnNextCall)
+        scope.instances[kind] = lazy
+    }
+    scope.mu.Unlock()
+    return lazy.Get()
+}
 
-    return singleInstance
+func (s *Scope) isClosed() bool {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.closed
+}
+
+// Scopes keeps one scope per key, e.g. per tenant
+type Scopes struct {
+    mu     sync.Mutex
+    scopes map[string]*Scope
+    order  []string
+}
+
+func NewScopes() *Scopes {
+    return &Scopes{scopes: map[string]*Scope{}}
+}
+
+func (s *Scopes) For(key string) *Scope {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    scope, ok := s.scopes[key]
+    if !ok {
+        scope = NewScope(key)
+        s.scopes[key] = scope
+        s.order = append(s.order, key)
+    }
+    return scope
+}
+
+// Reset closes the key's scope and forgets it
+func (s *Scopes) Reset(key string) error {
+    s.mu.Lock()
+    scope, ok := s.scopes[key]
+    delete(s.scopes, key)
+    for i, k := range s.order {
+        if k == key {
+            s.order = append(s.order[:i], s.order[i+1:]...)
+            break
+        }
+    }
+    s.mu.Unlock()
+    if !ok {
+        return nil
+    }
+    return scope.Close()
+}
+
+// Close shuts every scope down, newest first
+func (s *Scopes) Close() error {
+    s.mu.Lock()
+    order := s.order
+    s.mu.Unlock()
+    var errs []error
+    for i := len(order) - 1; i >= 0; i-- {
+        errs = append(errs, s.Reset(order[i]))
+    }
+    return errors.Join(errs...)
+}
+
+type scopeKey struct{}
+
+// WithScope attaches a scope to a context, e.g. for the length of a request
+func WithScope(ctx context.Context, scope *Scope) context.Context {
+    return context.WithValue(ctx, scopeKey{}, scope)
+}
+
+func ScopeFrom(ctx context.Context) (*Scope, bool) {
+    scope, ok := ctx.Value(scopeKey{}).(*Scope)
+    return scope, ok
+}
+
+// Multiton
+// One instance per key, each built lazily by its own Lazy, so concurrent first
+// access to a key constructs it once and a failing key never blocks or poisons
+// the others. Entries can be removed or evicted once idle; io.Closer values
+// are closed when they go
+type Multiton[K comparable, V any] struct {
+    construct   func(key K) (V, error)
+    idleTimeout time.Duration
+    mu          sync.Mutex
+    entries     map[K]*multitonEntry[V]
+}
+
+type multitonEntry[V any] struct {
+    lazy *Lazy[V]
+    // lastUsed and getting are guarded by the Multiton's mu; an entry with
+    // Gets in flight, e.g. one still being constructed, is never idle
+    lastUsed time.Time
+    getting  int
+    // mu guards dead and closed. An entry removed while its value is still
+    // being constructed is closed by the Get that finishes constructing it
+    mu     sync.Mutex
+    dead   bool
+    closed bool
+}
+
+// NewMultiton makes a registry; an idleTimeout of zero disables idle eviction
+func NewMultiton[K comparable, V any](construct func(key K) (V, error), idleTimeout time.Duration) *Multiton[K, V] {
+    return &Multiton[K, V]{construct: construct, idleTimeout: idleTimeout, entries: map[K]*multitonEntry[V]{}}
+}
+
+// Get never hands out a value whose entry was removed during construction;
+// it closes that value and tries again with a fresh entry
+func (m *Multiton[K, V]) Get(key K) (V, error) {
+    for {
+        m.mu.Lock()
+        entry, ok := m.entries[key]
+        if !ok {
+            entry = &multitonEntry[V]{lazy: NewLazy(func() (V, error) { return m.construct(key) }, RetryOnNextCall)}
+            m.entries[key] = entry
+        }
+        entry.getting++
+        m.mu.Unlock()
+        value, err := entry.lazy.Get()
+        m.mu.Lock()
+        entry.getting--
+        entry.lastUsed = time.Now()
+        m.mu.Unlock()
+        if err != nil || entry.alive() {
+            return value, err
+        }
+    }
+}
+
+// Remove drops the key's instance; the next Get constructs a new one
+func (m *Multiton[K, V]) Remove(key K) error {
+    m.mu.Lock()
+    entry, ok := m.entries[key]
+    delete(m.entries, key)
+    m.mu.Unlock()
+    if !ok {
+        return nil
+    }
+    return entry.release()
+}
+
+// EvictIdle removes the entries that have not been used for idleTimeout,
+// counting from the end of their last Get
+func (m *Multiton[K, V]) EvictIdle() ([]K, error) {
+    if m.idleTimeout <= 0 {
+        return nil, nil
+    }
+    cutoff := time.Now().Add(-m.idleTimeout)
+    var evicted []K
+    var idle []*multitonEntry[V]
+    m.mu.Lock()
+    for key, entry := range m.entries {
+        if entry.getting == 0 && entry.lastUsed.Before(cutoff) {
+            evicted = append(evicted, key)
+            idle = append(idle, entry)
+            delete(m.entries, key)
+        }
+    }
+    m.mu.Unlock()
+    var errs []error
+    for _, entry := range idle {
+        errs = append(errs, entry.release())
+    }
+    return evicted, errors.Join(errs...)
+}
+
+// EvictEvery runs EvictIdle on a ticker until ctx is done
+func (m *Multiton[K, V]) EvictEvery(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            m.EvictIdle()
+        }
+    }
+}
+
+func (m *Multiton[K, V]) Len() int {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    return len(m.entries)
+}
+
+// Close removes every entry
+func (m *Multiton[K, V]) Close() error {
+    m.mu.Lock()
+    entries := m.entries
+    m.entries = map[K]*multitonEntry[V]{}
+    m.mu.Unlock()
+    var errs []error
+    for _, entry := range entries {
+        errs = append(errs, entry.release())
+    }
+    return errors.Join(errs...)
+}
+
+// release marks a removed entry dead and closes its value if there is one yet
+func (e *multitonEntry[V]) release() error {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    e.dead = true
+    if !e.lazy.Initialized() {
+        return nil
+    }
+    return e.closeValue()
+}
+
+// alive reports whether the value just constructed may be handed out, and
+// closes it if the entry was released in the meantime
+func (e *multitonEntry[V]) alive() bool {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    if !e.dead {
+        return true
+    }
+    e.closeValue()
+    return false
+}
+
+// closeValue closes an io.Closer value once; e.mu must be held
+func (e *multitonEntry[V]) closeValue() error {
+    if e.closed {
+        return nil
+    }
+    e.closed = true
+    value, _ := e.lazy.Get()
+    if closer, ok := any(value).(io.Closer); ok {
+        return closer.Close()
+    }
+    return nil
+}
+
+// regionClient is the kind of thing there should be one of per region
+type regionClient struct {
+    region string
+}
+
+func (c *regionClient) Close() error {
+    fmt.Println("Closing client for " + c.region)
+    return nil
+}
+
+// tenantDB is a per-tenant resource with a Close hook
+type tenantDB struct {
+    tenant string
+}
+
+func (db *tenantDB) Close() error {
+    fmt.Println("Closing database for " + db.tenant)
+    return nil
+}
+
+type tenantCache struct {
+    tenant string
+}
+
+func (c *tenantCache) Close() error {
+    fmt.Println("Closing cache for " + c.tenant)
+    return nil
+}
+
+type config struct {
+    dsn string
 }
 
 // client code
 func main() {
-
     for i := 0; i < 30; i++ {
         go getInstance()
     }
@@ -39,4 +543,92 @@ func main() {
     // Scanln is similar to Scan, but stops scanning at a newline and
     // after the final item there must be a newline or EOF.
     fmt.Scanln()
+
+    failures := 2
+    loadConfig := func() (*config, error) {
+        if failures > 0 {
+            failures--
+            return nil, errors.New("config server unavailable")
+        }
+        return &config{dsn: "postgres://localhost/app"}, nil
+    }
+
+    retrying := NewLazy(loadConfig, RetryOnNextCall)
+    var wg sync.WaitGroup
+    for i := 0; i < 1000; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            retrying.Get()
+        }()
+    }
+    wg.Wait()
+    cfg, err := retrying.Get()
+    fmt.Printf("Retrying lazy: initialized=%v after %d attempts, dsn=%s, err=%v\n", retrying.Initialized(), retrying.Attempts(), cfg.dsn, err)
+
+    failures = 1
+    permanent := NewLazy(loadConfig, FailPermanently)
+    permanent.Get()
+    _, err = permanent.Get()
+    fmt.Printf("Permanent lazy: initialized=%v after %d attempts, err=%v\n", permanent.Initialized(), permanent.Attempts(), err)
+
+    resetInstance()
+    fmt.Print("After reset: ")
+    getInstance()
+
+    tenants := NewScopes()
+    for _, tenant := range []string{"acme", "globex", "acme"} {
+        scope := tenants.For(tenant)
+        ctx := WithScope(context.Background(), scope)
+        handleRequest(ctx)
+    }
+    fmt.Println("Resetting acme:")
+    tenants.Reset("acme")
+    fmt.Println("Shutting down:")
+    tenants.Close()
+
+    var constructions, failedAttempts atomic.Int64
+    clients := NewMultiton(func(region string) (*regionClient, error) {
+        if region == "mars-1" {
+            failedAttempts.Add(1)
+            return nil, errors.New("no such region " + region)
+        }
+        constructions.Add(1)
+        return &regionClient{region: region}, nil
+    }, 20*time.Millisecond)
+    var requests sync.WaitGroup
+    for i := 0; i < 300; i++ {
+        requests.Add(1)
+        go func() {
+            defer requests.Done()
+            clients.Get([]string{"eu-west-1", "us-east-1", "mars-1"}[i%3])
+        }()
+    }
+    requests.Wait()
+    _, marsErr := clients.Get("mars-1")
+    eu, euErr := clients.Get("eu-west-1")
+    fmt.Printf("Multiton: %d entries, eu-west-1=%s (err=%v), mars-1 err=%v\n", clients.Len(), eu.region, euErr, marsErr)
+    fmt.Printf("Multiton: 2 healthy regions constructed %d time(s); mars-1 failed %d time(s) without affecting them\n", constructions.Load(), failedAttempts.Load())
+
+    time.Sleep(30 * time.Millisecond)
+    clients.Get("us-east-1")
+    evicted, _ := clients.EvictIdle()
+    sort.Strings(evicted)
+    fmt.Printf("Multiton: evicted idle %v, %d entries left\n", evicted, clients.Len())
+    clients.Remove("us-east-1")
+    fmt.Printf("Multiton: %d entries left after removing us-east-1\n", clients.Len())
+}
+
+// handleRequest finds the tenant's singletons through the request context
+func handleRequest(ctx context.Context) {
+    scope, _ := ScopeFrom(ctx)
+    db, _ := Scoped(scope, func() (*tenantDB, error) {
+        fmt.Println("Opening database for " + scope.Name())
+        return &tenantDB{tenant: scope.Name()}, nil
+    })
+    cache, _ := Scoped(scope, func() (*tenantCache, error) {
+        fmt.Println("Opening cache for " + scope.Name())
+        return &tenantCache{tenant: scope.Name()}, nil
+    })
+    fmt.Printf("Handled request for %s (database of %s, cache of %s)\n", scope.Name(), db.tenant, cache.tenant)
 }
\ No newline at end of file
@@ -0,0 +1,276 @@
+package main
+
+import (
+    "errors"
+    "io"
+    "os"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+var variants = []struct {
+    name string
+    new  func(construct func() *single) singletonVariant
+}{
+    {"mutex", func(construct func() *single) singletonVariant { return &mutexSingleton{construct: construct} }},
+    {"once", func(construct func() *single) singletonVariant { return &onceSingleton{construct: construct} }},
+    {"atomic", func(construct func() *single) singletonVariant { return &atomicSingleton{construct: construct} }},
+    {"lazy", func(construct func() *single) singletonVariant {
+        return lazySingleton{NewLazy(func() (*single, error) { return construct(), nil }, FailPermanently)}
+    }},
+}
+
+// hammer releases goroutines all at once against get and returns what each saw
+func hammer(goroutines int, get func() *single) []*single {
+    start := make(chan struct{})
+    results := make([]*single, goroutines)
+    var wg sync.WaitGroup
+    for i := 0; i < goroutines; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            <-start
+            results[i] = get()
+        }()
+    }
+    close(start)
+    wg.Wait()
+    return results
+}
+
+func checkSame(t *testing.T, results []*single) {
+    t.Helper()
+    for _, result := range results {
+        if result == nil || result != results[0] {
+            t.Fatalf("goroutines saw different instances: %p and %p", results[0], result)
+        }
+    }
+}
+
+// Run with go test -race
+func TestGetInstanceRace(t *testing.T) {
+    resetInstance()
+    output = io.Discard
+    t.Cleanup(func() {
+        resetInstance()
+        output = os.Stdout
+    })
+    checkSame(t, hammer(5000, getInstance))
+}
+
+func TestVariantsRace(t *testing.T) {
+    for _, variant := range variants {
+        t.Run(variant.name, func(t *testing.T) {
+            var constructed atomic.Int64
+            singleton := variant.new(func() *single {
+                constructed.Add(1)
+                return &single{}
+            })
+            checkSame(t, hammer(1000, singleton.get))
+            if n := constructed.Load(); n != 1 {
+                t.Errorf("constructed %d times", n)
+            }
+        })
+    }
+}
+
+type countingCloser struct {
+    closes atomic.Int32
+}
+
+func (c *countingCloser) Close() error {
+    c.closes.Add(1)
+    return nil
+}
+
+func TestMultitonRemoveDuringConstruction(t *testing.T) {
+    started := make(chan struct{})
+    proceed := make(chan struct{})
+    var built []*countingCloser
+    multiton := NewMultiton(func(key string) (*countingCloser, error) {
+        value := &countingCloser{}
+        built = append(built, value)
+        if len(built) == 1 {
+            close(started)
+            <-proceed
+        }
+        return value, nil
+    }, 0)
+
+    got := make(chan *countingCloser)
+    go func() {
+        value, _ := multiton.Get("key")
+        got <- value
+    }()
+    <-started
+    if err := multiton.Remove("key"); err != nil {
+        t.Fatal(err)
+    }
+    close(proceed)
+    value := <-got
+
+    if len(built) != 2 {
+        t.Fatalf("constructed %d values, want the removed one and a fresh one", len(built))
+    }
+    if n := built[0].closes.Load(); n != 1 {
+        t.Errorf("value removed during construction was closed %d times, want 1", n)
+    }
+    if value != built[1] || value.closes.Load() != 0 {
+        t.Error("Get did not return the fresh, open value")
+    }
+    multiton.Close()
+    if n := built[1].closes.Load(); n != 1 {
+        t.Errorf("Close closed the live value %d times, want 1", n)
+    }
+}
+
+// benchmarkVariant measures the fast path once the instance exists, with
+// every CPU calling get
+func benchmarkVariant(b *testing.B, name string) {
+    for _, variant := range variants {
+        if variant.name != name {
+            continue
+        }
+        singleton := variant.new(func() *single { return &single{} })
+        singleton.get()
+        b.RunParallel(func(pb *testing.PB) {
+            for pb.Next() {
+                singleton.get()
+            }
+        })
+    }
+}
+
+func BenchmarkMutex(b *testing.B)  { benchmarkVariant(b, "mutex") }
+func BenchmarkOnce(b *testing.B)   { benchmarkVariant(b, "once") }
+func BenchmarkAtomic(b *testing.B) { benchmarkVariant(b, "atomic") }
+func BenchmarkLazy(b *testing.B)   { benchmarkVariant(b, "lazy") }
+
+func TestClosedScopeRefusesInstances(t *testing.T) {
+    scopes := NewScopes()
+    scope := scopes.For("acme")
+    first, err := Scoped(scope, func() (*countingCloser, error) { return &countingCloser{}, nil })
+    if err != nil {
+        t.Fatal(err)
+    }
+    scopes.Reset("acme")
+    if n := first.closes.Load(); n != 1 {
+        t.Errorf("Reset closed the instance %d times, want 1", n)
+    }
+    if _, err := Scoped(scope, func() (*countingCloser, error) { return &countingCloser{}, nil }); err == nil {
+        t.Error("Scoped built an instance in a scope that was reset")
+    }
+    if scope.Close() != nil || first.closes.Load() != 1 {
+        t.Error("closing the scope twice ran its hooks again")
+    }
+    if scopes.For("acme") == scope {
+        t.Error("For handed out the closed scope again")
+    }
+}
+
+func TestScopeClosedDuringConstruction(t *testing.T) {
+    scope := NewScope("request")
+    started := make(chan struct{})
+    proceed := make(chan struct{})
+    value := &countingCloser{}
+    done := make(chan error)
+    go func() {
+        _, err := Scoped(scope, func() (*countingCloser, error) {
+            close(started)
+            <-proceed
+            return value, nil
+        })
+        done <- err
+    }()
+    <-started
+    scope.Close()
+    close(proceed)
+    if err := <-done; err == nil {
+        t.Error("Scoped returned an instance of a scope closed while building it")
+    }
+    if n := value.closes.Load(); n != 1 {
+        t.Errorf("the orphaned instance was closed %d times, want 1", n)
+    }
+}
+
+func TestMultitonEvictIdle(t *testing.T) {
+    started := make(chan struct{})
+    proceed := make(chan struct{})
+    built := map[string]*countingCloser{}
+    var mu sync.Mutex
+    multiton := NewMultiton(func(key string) (*countingCloser, error) {
+        if key == "slow" {
+            close(started)
+            <-proceed
+        }
+        value := &countingCloser{}
+        mu.Lock()
+        built[key] = value
+        mu.Unlock()
+        return value, nil
+    }, 20*time.Millisecond)
+    defer multiton.Close()
+
+    multiton.Get("idle")
+    multiton.Get("busy")
+    slow := make(chan *countingCloser)
+    go func() {
+        value, _ := multiton.Get("slow")
+        slow <- value
+    }()
+    <-started
+    time.Sleep(40 * time.Millisecond)
+    multiton.Get("busy")
+
+    evicted, err := multiton.EvictIdle()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(evicted) != 1 || evicted[0] != "idle" {
+        t.Errorf("evicted %v, want only the idle key, not the busy one or the one still constructing", evicted)
+    }
+    if n := built["idle"].closes.Load(); n != 1 {
+        t.Errorf("evicted value closed %d times, want 1", n)
+    }
+    close(proceed)
+    if value := <-slow; value == nil || value.closes.Load() != 0 {
+        t.Error("the value constructed across an eviction was lost or closed")
+    }
+    if multiton.Len() != 2 {
+        t.Errorf("%d entries left, want busy and slow", multiton.Len())
+    }
+}
+
+func TestMultitonErrorsStayPerKey(t *testing.T) {
+    var failures atomic.Int32
+    multiton := NewMultiton(func(key string) (*countingCloser, error) {
+        if key == "broken" && failures.Add(1) <= 3 {
+            return nil, errors.New("broken is down")
+        }
+        return &countingCloser{}, nil
+    }, 0)
+    defer multiton.Close()
+
+    var wg sync.WaitGroup
+    for i := 0; i < 100; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            key := []string{"healthy", "broken"}[i%2]
+            if _, err := multiton.Get(key); err != nil && key == "healthy" {
+                t.Errorf("healthy key failed: %v", err)
+            }
+        }()
+    }
+    wg.Wait()
+    healthy, _ := multiton.Get("healthy")
+    again, _ := multiton.Get("healthy")
+    if healthy == nil || healthy != again {
+        t.Error("the healthy key was not constructed once and shared")
+    }
+    if _, err := multiton.Get("broken"); err != nil {
+        t.Errorf("broken key was not retried after recovering: %v", err)
+    }
+}
@@ -1,6 +1,17 @@
 package main
 
-import "fmt"
+import (
+    "bufio"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "strings"
+    "sync"
+    "unicode/utf16"
+    "unicode/utf8"
+)
 
 // Service
 type Mac struct {
@@ -18,6 +29,9 @@ func (w *Windows) insertIntoUSBPort() {
 }
 
 // Adapter
+// WindowsComputerAdapter in windows_adapter_gen.go is the same adapter written
+// by adaptergen from windows_adapter.map
+//go:generate go run adaptergen/adaptergen.go -src Adapter.go -target Computer -adaptee Windows -map windows_adapter.map -o windows_adapter_gen.go
 type WindowsAdapter struct {
     windowMachine *Windows
 }
@@ -41,6 +55,534 @@ func (c *Client) InsertLightningConnectorIntoComputer(com Computer) {
     com.InsertIntoLightningPort()
 }
 
+// Port adapter graph
+// Sockets accept one kind of connector; a PortAdapter accepts a connector of
+// its From port and plugs into a socket of its To port. Chaining adapters
+// gets a client's connector into a device it was never meant for
+type Port string
+
+const (
+    Lightning Port = "Lightning"
+    USBC      Port = "USB-C"
+    USBA      Port = "USB-A"
+    HDMI      Port = "HDMI"
+)
+
+type Socket interface {
+    Port() Port
+    Insert()
+}
+
+// A bidirectional adapter also accepts a connector of To and plugs into From
+type PortAdapter struct {
+    From          Port
+    To            Port
+    Bidirectional bool
+}
+
+// adaptedSocket is a socket made by plugging an adapter into another socket
+type adaptedSocket struct {
+    adapter PortAdapter
+    device  Socket
+}
+
+func (s *adaptedSocket) Port() Port {
+    return s.adapter.From
+}
+
+func (s *adaptedSocket) Insert() {
+    fmt.Printf("Adapter converts %s signal to %s.\n", s.adapter.From, s.adapter.To)
+    s.device.Insert()
+}
+
+type AdapterGraph struct {
+    adapters map[Port][]PortAdapter
+}
+
+func NewAdapterGraph(adapters ...PortAdapter) *AdapterGraph {
+    graph := &AdapterGraph{adapters: map[Port][]PortAdapter{}}
+    for _, adapter := range adapters {
+        graph.Register(adapter)
+    }
+    return graph
+}
+
+func (g *AdapterGraph) Register(adapter PortAdapter) {
+    g.adapters[adapter.From] = append(g.adapters[adapter.From], adapter)
+    if adapter.Bidirectional {
+        reverse := PortAdapter{From: adapter.To, To: adapter.From, Bidirectional: true}
+        g.adapters[reverse.From] = append(g.adapters[reverse.From], reverse)
+    }
+}
+
+// NoPathError lists the ports that could be reached from From, none of which led to To
+type NoPathError struct {
+    From  Port
+    To    Port
+    Tried []Port
+}
+
+func (e *NoPathError) Error() string {
+    tried := make([]string, len(e.Tried))
+    for i, port := range e.Tried {
+        tried[i] = string(port)
+    }
+    return fmt.Sprintf("no adapter chain from %s to %s (reachable ports: %s)", e.From, e.To, strings.Join(tried, ", "))
+}
+
+// ShortestChain finds the fewest adapters leading from one port to another
+// with a breadth-first search; an empty chain means the ports already match
+func (g *AdapterGraph) ShortestChain(from Port, to Port) ([]PortAdapter, error) {
+    if from == to {
+        return nil, nil
+    }
+    via := map[Port]PortAdapter{}
+    visited := map[Port]bool{from: true}
+    tried := []Port{from}
+    queue := []Port{from}
+    for len(queue) > 0 {
+        port := queue[0]
+        queue = queue[1:]
+        for _, adapter := range g.adapters[port] {
+            if visited[adapter.To] {
+                continue
+            }
+            visited[adapter.To] = true
+            via[adapter.To] = adapter
+            if adapter.To == to {
+                var chain []PortAdapter
+                for p := to; p != from; p = via[p].From {
+                    chain = append([]PortAdapter{via[p]}, chain...)
+                }
+                return chain, nil
+            }
+            tried = append(tried, adapter.To)
+            queue = append(queue, adapter.To)
+        }
+    }
+    return nil, &NoPathError{From: from, To: to, Tried: tried}
+}
+
+// Connect wraps device in the adapters needed to take a connector of port
+func (g *AdapterGraph) Connect(port Port, device Socket) (Socket, error) {
+    chain, err := g.ShortestChain(port, device.Port())
+    if err != nil {
+        return nil, err
+    }
+    socket := device
+    for i := len(chain) - 1; i >= 0; i-- {
+        socket = &adaptedSocket{adapter: chain[i], device: socket}
+    }
+    return socket, nil
+}
+
+// Capability negotiation
+// Machines declare the ports they have; the graph picks the connection that
+// needs the fewest adapters, so a native port always wins
+type Machine interface {
+    Name() string
+    Ports() []Port
+    InsertInto(port Port) error
+}
+
+func (m *Mac) Name() string {
+    return "mac"
+}
+
+func (m *Mac) Ports() []Port {
+    return []Port{Lightning, USBC}
+}
+
+func (m *Mac) InsertInto(port Port) error {
+    switch port {
+    case Lightning:
+        m.InsertIntoLightningPort()
+    case USBC:
+        fmt.Println("USB-C connector is plugged into mac machine.")
+    default:
+        return fmt.Errorf("mac has no %s port", port)
+    }
+    return nil
+}
+
+func (w *Windows) Name() string {
+    return "windows"
+}
+
+func (w *Windows) Ports() []Port {
+    return []Port{USBA}
+}
+
+func (w *Windows) InsertInto(port Port) error {
+    if port != USBA {
+        return fmt.Errorf("windows has no %s port", port)
+    }
+    w.insertIntoUSBPort()
+    return nil
+}
+
+// machineSocket is one port of a machine
+type machineSocket struct {
+    machine Machine
+    port    Port
+}
+
+func (s machineSocket) Port() Port { return s.port }
+func (s machineSocket) Insert()    { s.machine.InsertInto(s.port) }
+
+// Peripheral is something with one or more connectors, in order of preference
+type Peripheral struct {
+    Name       string
+    Connectors []Port
+}
+
+type Connection struct {
+    Connector Port
+    Port      Port
+    Chain     []PortAdapter
+    socket    Socket
+}
+
+func (c *Connection) Native() bool {
+    return len(c.Chain) == 0
+}
+
+func (c *Connection) Insert() {
+    c.socket.Insert()
+}
+
+func (c *Connection) String() string {
+    if c.Native() {
+        return fmt.Sprintf("%s connector into native %s port", c.Connector, c.Port)
+    }
+    hops := []string{string(c.Connector)}
+    for _, adapter := range c.Chain {
+        hops = append(hops, string(adapter.To))
+    }
+    return fmt.Sprintf("%s connector through %d adapter(s) %s", c.Connector, len(c.Chain), strings.Join(hops, " -> "))
+}
+
+// Negotiate tries every connector against every port of the machine
+func (g *AdapterGraph) Negotiate(connectors []Port, machine Machine) (*Connection, error) {
+    var best *Connection
+    for _, connector := range connectors {
+        for _, port := range machine.Ports() {
+            chain, err := g.ShortestChain(connector, port)
+            if err != nil {
+                continue
+            }
+            if best == nil || len(chain) < len(best.Chain) {
+                best = &Connection{Connector: connector, Port: port, Chain: chain}
+            }
+        }
+    }
+    if best == nil {
+        return nil, fmt.Errorf("cannot connect %v to %s with ports %v", connectors, machine.Name(), machine.Ports())
+    }
+    best.socket, _ = g.Connect(best.Connector, machineSocket{machine: machine, port: best.Port})
+    return best, nil
+}
+
+func (c *Client) ConnectPeripheral(peripheral Peripheral, machine Machine, graph *AdapterGraph) {
+    connection, err := graph.Negotiate(peripheral.Connectors, machine)
+    if err != nil {
+        fmt.Println("Client cannot connect " + peripheral.Name + ": " + err.Error())
+        return
+    }
+    fmt.Printf("Client connects %s to %s: %s.\n", peripheral.Name, machine.Name(), connection)
+    connection.Insert()
+}
+
+// Data-carrying connections
+// Every port speaks a protocol: how a byte stream is cut into messages and
+// how their text is encoded. A stream adapter sits between two pipes and
+// translates each message from the protocol of one port to the other
+type Framing interface {
+    WriteFrame(w io.Writer, frame []byte) error
+    ReadFrame(r *bufio.Reader) ([]byte, error)
+}
+
+// MaxFrameSize bounds a frame in every framing, so a corrupt or hostile
+// peer cannot make the reader allocate gigabytes
+const MaxFrameSize = 16 << 20
+
+var ErrFrameTooLarge = errors.New("frame too large")
+
+// LengthPrefixed puts a 4-byte big-endian length before every frame
+type LengthPrefixed struct{}
+
+func (LengthPrefixed) WriteFrame(w io.Writer, frame []byte) error {
+    if len(frame) > MaxFrameSize {
+        return fmt.Errorf("%w: %d bytes, limit %d", ErrFrameTooLarge, len(frame), MaxFrameSize)
+    }
+    var header [4]byte
+    binary.BigEndian.PutUint32(header[:], uint32(len(frame)))
+    if _, err := w.Write(header[:]); err != nil {
+        return err
+    }
+    _, err := w.Write(frame)
+    return err
+}
+
+func (LengthPrefixed) ReadFrame(r *bufio.Reader) ([]byte, error) {
+    var header [4]byte
+    if _, err := io.ReadFull(r, header[:]); err != nil {
+        return nil, err
+    }
+    size := binary.BigEndian.Uint32(header[:])
+    if size > MaxFrameSize {
+        return nil, fmt.Errorf("%w: header announces %d bytes, limit %d", ErrFrameTooLarge, size, MaxFrameSize)
+    }
+    frame := make([]byte, size)
+    if _, err := io.ReadFull(r, frame); err != nil {
+        if err == io.EOF {
+            err = io.ErrUnexpectedEOF
+        }
+        return nil, err
+    }
+    return frame, nil
+}
+
+// NewlineDelimited ends every frame with a newline; newlines and backslashes
+// inside a frame are escaped so any bytes survive
+type NewlineDelimited struct{}
+
+func (NewlineDelimited) WriteFrame(w io.Writer, frame []byte) error {
+    if len(frame) > MaxFrameSize {
+        return fmt.Errorf("%w: %d bytes, limit %d", ErrFrameTooLarge, len(frame), MaxFrameSize)
+    }
+    line := make([]byte, 0, len(frame)+1)
+    for _, b := range frame {
+        switch b {
+        case '\\':
+            line = append(line, '\\', '\\')
+        case '\n':
+            line = append(line, '\\', 'n')
+        default:
+            line = append(line, b)
+        }
+    }
+    _, err := w.Write(append(line, '\n'))
+    return err
+}
+
+// ReadFrame unescapes the line one buffer at a time, so it stops at
+// MaxFrameSize instead of buffering a line that never ends
+func (NewlineDelimited) ReadFrame(r *bufio.Reader) ([]byte, error) {
+    frame := []byte{}
+    started, escaped := false, false
+    for {
+        chunk, err := r.ReadSlice('\n')
+        if err != nil && err != bufio.ErrBufferFull {
+            if err == io.EOF && (started || len(chunk) > 0) {
+                err = io.ErrUnexpectedEOF
+            }
+            return nil, err
+        }
+        started = true
+        data := chunk
+        if err == nil {
+            data = chunk[:len(chunk)-1]
+        }
+        for _, b := range data {
+            if escaped {
+                switch b {
+                case '\\':
+                case 'n':
+                    b = '\n'
+                default:
+                    return nil, fmt.Errorf("unknown escape \\%c in newline-delimited frame", b)
+                }
+                escaped = false
+            } else if b == '\\' {
+                escaped = true
+                continue
+            }
+            if len(frame) == MaxFrameSize {
+                return nil, fmt.Errorf("%w: frame longer than %d bytes", ErrFrameTooLarge, MaxFrameSize)
+            }
+            frame = append(frame, b)
+        }
+        if err == nil {
+            if escaped {
+                return nil, errors.New("newline-delimited frame ends with a lone backslash")
+            }
+            return frame, nil
+        }
+    }
+}
+
+type Encoding interface {
+    Encode(text string) ([]byte, error)
+    Decode(data []byte) (string, error)
+}
+
+type UTF8 struct{}
+
+func (UTF8) Encode(text string) ([]byte, error) {
+    if !utf8.ValidString(text) {
+        return nil, errors.New("text is not valid UTF-8")
+    }
+    return []byte(text), nil
+}
+
+func (UTF8) Decode(data []byte) (string, error) {
+    if !utf8.Valid(data) {
+        return "", errors.New("frame is not valid UTF-8")
+    }
+    return string(data), nil
+}
+
+type UTF16LE struct{}
+
+func (UTF16LE) Encode(text string) ([]byte, error) {
+    if !utf8.ValidString(text) {
+        return nil, errors.New("text is not valid UTF-8")
+    }
+    units := utf16.Encode([]rune(text))
+    data := make([]byte, 2*len(units))
+    for i, unit := range units {
+        binary.LittleEndian.PutUint16(data[2*i:], unit)
+    }
+    return data, nil
+}
+
+func (UTF16LE) Decode(data []byte) (string, error) {
+    if len(data)%2 != 0 {
+        return "", fmt.Errorf("UTF-16 frame has odd length %d", len(data))
+    }
+    units := make([]uint16, len(data)/2)
+    for i := range units {
+        units[i] = binary.LittleEndian.Uint16(data[2*i:])
+    }
+    return string(utf16.Decode(units)), nil
+}
+
+type Protocol struct {
+    Framing  Framing
+    Encoding Encoding
+}
+
+var Protocols = map[Port]Protocol{
+    Lightning: {Framing: LengthPrefixed{}, Encoding: UTF8{}},
+    USBC:      {Framing: NewlineDelimited{}, Encoding: UTF8{}},
+    USBA:      {Framing: NewlineDelimited{}, Encoding: UTF16LE{}},
+}
+
+// Messenger sends and receives text messages over a stream in one protocol
+type Messenger struct {
+    protocol Protocol
+    reader   *bufio.Reader
+    writer   io.Writer
+}
+
+func NewMessenger(stream io.ReadWriter, protocol Protocol) *Messenger {
+    return &Messenger{protocol: protocol, reader: bufio.NewReader(stream), writer: stream}
+}
+
+func (m *Messenger) Send(text string) error {
+    frame, err := m.protocol.Encoding.Encode(text)
+    if err != nil {
+        return err
+    }
+    return m.protocol.Framing.WriteFrame(m.writer, frame)
+}
+
+func (m *Messenger) Receive() (string, error) {
+    frame, err := m.protocol.Framing.ReadFrame(m.reader)
+    if err != nil {
+        return "", err
+    }
+    return m.protocol.Encoding.Decode(frame)
+}
+
+// Line is an open data connection. The client talks to Client in the protocol
+// of its connector, the device to Device in the protocol of its port
+type Line struct {
+    Client io.ReadWriteCloser
+    Device io.ReadWriteCloser
+    wait   sync.WaitGroup
+    mu     sync.Mutex
+    err    error
+}
+
+// Dial lays pipes along the shortest adapter chain and starts a stream
+// adapter on every hop
+func (g *AdapterGraph) Dial(connector Port, port Port) (*Line, error) {
+    chain, err := g.ShortestChain(connector, port)
+    if err != nil {
+        return nil, err
+    }
+    for _, p := range []Port{connector, port} {
+        if _, ok := Protocols[p]; !ok {
+            return nil, fmt.Errorf("%s port carries no data", p)
+        }
+    }
+    line := &Line{}
+    client, left := net.Pipe()
+    line.Client = client
+    for _, adapter := range chain {
+        right, next := net.Pipe()
+        from := NewMessenger(left, Protocols[adapter.From])
+        to := NewMessenger(right, Protocols[adapter.To])
+        line.wait.Add(2)
+        go line.relay(adapter, from, to, left, right)
+        go line.relay(adapter, to, from, left, right)
+        left = next
+    }
+    line.Device = left
+    return line, nil
+}
+
+// relay moves messages one way through an adapter until either side closes;
+// a broken message tears the whole hop down
+func (l *Line) relay(adapter PortAdapter, src *Messenger, dst *Messenger, ends ...io.Closer) {
+    defer l.wait.Done()
+    defer func() {
+        for _, end := range ends {
+            end.Close()
+        }
+    }()
+    for {
+        text, err := src.Receive()
+        if err == nil {
+            err = dst.Send(text)
+        }
+        if err != nil {
+            if err != io.EOF && !errors.Is(err, io.ErrClosedPipe) {
+                l.mu.Lock()
+                if l.err == nil {
+                    l.err = fmt.Errorf("%s to %s adapter: %w", adapter.From, adapter.To, err)
+                }
+                l.mu.Unlock()
+            }
+            return
+        }
+    }
+}
+
+// Wait returns once every adapter on the line has stopped, with the first
+// error any of them hit
+func (l *Line) Wait() error {
+    l.wait.Wait()
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    return l.err
+}
+
+// echo is a device that answers every message with the same message
+func echo(device *Messenger) {
+    for {
+        text, err := device.Receive()
+        if err != nil {
+            return
+        }
+        if device.Send(text) != nil {
+            return
+        }
+    }
+}
+
 // main
 
 func main() {
@@ -56,4 +598,52 @@ func main() {
     }
 
     client.InsertLightningConnectorIntoComputer(windowsMachineAdapter)
+
+    graph := NewAdapterGraph(
+        PortAdapter{From: Lightning, To: USBC, Bidirectional: true},
+        PortAdapter{From: USBC, To: USBA, Bidirectional: true},
+    )
+    fmt.Println("\nClient inserts Lightning connector into windows machine through an adapter chain.")
+    socket, err := graph.Connect(Lightning, machineSocket{machine: windowsMachine, port: USBA})
+    if err != nil {
+        fmt.Println(err)
+        return
+    }
+    socket.Insert()
+
+    fmt.Println("\nClient negotiates the best connection for each peripheral.")
+    peripherals := []Peripheral{
+        {Name: "iPhone cable", Connectors: []Port{Lightning}},
+        {Name: "USB stick", Connectors: []Port{USBA}},
+        {Name: "dock", Connectors: []Port{USBA, USBC}},
+        {Name: "monitor", Connectors: []Port{HDMI}},
+    }
+    for _, machine := range []Machine{mac, windowsMachine} {
+        for _, peripheral := range peripherals {
+            client.ConnectPeripheral(peripheral, machine, graph)
+        }
+    }
+
+    fmt.Println("\nClient looks for a way from Lightning to HDMI.")
+    if _, err := graph.ShortestChain(Lightning, HDMI); err != nil {
+        fmt.Println(err)
+    }
+
+    fmt.Println("\nClient sends text from a Lightning connector to an echoing windows machine.")
+    line, err := graph.Dial(Lightning, USBA)
+    if err != nil {
+        fmt.Println(err)
+        return
+    }
+    go echo(NewMessenger(line.Device, Protocols[USBA]))
+    messenger := NewMessenger(line.Client, Protocols[Lightning])
+    for _, text := range []string{"hello windows", "two\nlines", "ünïcödé 🔌"} {
+        messenger.Send(text)
+        reply, _ := messenger.Receive()
+        fmt.Printf("sent %q, got back %q\n", text, reply)
+    }
+    line.Client.Close()
+    if err := line.Wait(); err != nil {
+        fmt.Println(err)
+    }
 }
@@ -0,0 +1,144 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+    "strings"
+    "testing"
+)
+
+func testGraph() *AdapterGraph {
+    return NewAdapterGraph(
+        PortAdapter{From: Lightning, To: USBC, Bidirectional: true},
+        PortAdapter{From: USBC, To: USBA, Bidirectional: true},
+    )
+}
+
+// makePayloads builds messages of growing size out of text every framing
+// and encoding has to be careful with
+func makePayloads() []string {
+    pieces := []string{"plain ascii ", "line\nbreak ", "back\\slash ", "\\n ", "é ", "日本語 ", "🔌 ", "\x00 "}
+    var payloads []string
+    for _, size := range []int{0, 1, 1 << 10, 64 << 10, 1 << 20} {
+        var text strings.Builder
+        for i := 0; text.Len() < size; i++ {
+            text.WriteString(pieces[i%len(pieces)])
+        }
+        payloads = append(payloads, text.String())
+    }
+    return payloads
+}
+
+func TestLargePayloadsThroughChains(t *testing.T) {
+    graph := testGraph()
+    payloads := makePayloads()
+    routes := [][2]Port{{USBC, USBC}, {Lightning, USBC}, {Lightning, USBA}, {USBA, Lightning}}
+    for _, route := range routes {
+        t.Run(fmt.Sprintf("%s to %s", route[0], route[1]), func(t *testing.T) {
+            line, err := graph.Dial(route[0], route[1])
+            if err != nil {
+                t.Fatal(err)
+            }
+            go echo(NewMessenger(line.Device, Protocols[route[1]]))
+            client := NewMessenger(line.Client, Protocols[route[0]])
+            sent := make(chan error, 1)
+            go func() {
+                for _, payload := range payloads {
+                    if err := client.Send(payload); err != nil {
+                        sent <- err
+                        return
+                    }
+                }
+                sent <- nil
+            }()
+            for i, payload := range payloads {
+                got, err := client.Receive()
+                if err != nil {
+                    t.Errorf("payload %d: %v", i, err)
+                    break
+                }
+                if got != payload {
+                    t.Errorf("payload %d of %d bytes came back as %d different bytes", i, len(payload), len(got))
+                    break
+                }
+            }
+            line.Client.Close()
+            if err := errors.Join(<-sent, line.Wait()); err != nil {
+                t.Error(err)
+            }
+        })
+    }
+}
+
+func TestInvalidTextHangsUp(t *testing.T) {
+    line, err := testGraph().Dial(Lightning, USBA)
+    if err != nil {
+        t.Fatal(err)
+    }
+    go echo(NewMessenger(line.Device, Protocols[USBA]))
+    var frame bytes.Buffer
+    LengthPrefixed{}.WriteFrame(&frame, []byte{0xff, 0xfe})
+    line.Client.Write(frame.Bytes())
+    if _, err := NewMessenger(line.Client, Protocols[Lightning]).Receive(); err != io.EOF {
+        t.Errorf("Receive() error = %v, want io.EOF once the line hangs up", err)
+    }
+    line.Client.Close()
+    if err := line.Wait(); err == nil {
+        t.Error("the adapter did not report the invalid UTF-8")
+    }
+}
+
+func TestNoLineToHDMI(t *testing.T) {
+    _, err := testGraph().Dial(Lightning, HDMI)
+    var noPath *NoPathError
+    if !errors.As(err, &noPath) {
+        t.Errorf("Dial(Lightning, HDMI) error = %v, want a *NoPathError", err)
+    }
+}
+
+func TestFrameSizeLimit(t *testing.T) {
+    var header [4]byte
+    binary.BigEndian.PutUint32(header[:], 0xffffffff)
+    _, err := LengthPrefixed{}.ReadFrame(bufio.NewReader(bytes.NewReader(header[:])))
+    if !errors.Is(err, ErrFrameTooLarge) {
+        t.Errorf("ReadFrame of a 4 GiB header: %v, want ErrFrameTooLarge", err)
+    }
+    for _, framing := range []Framing{LengthPrefixed{}, NewlineDelimited{}} {
+        err = framing.WriteFrame(io.Discard, make([]byte, MaxFrameSize+1))
+        if !errors.Is(err, ErrFrameTooLarge) {
+            t.Errorf("%T.WriteFrame above the limit: %v, want ErrFrameTooLarge", framing, err)
+        }
+    }
+}
+
+// endless is a stream that never sends a newline
+type endless struct{}
+
+func (endless) Read(p []byte) (int, error) {
+    for i := range p {
+        p[i] = 'a'
+    }
+    return len(p), nil
+}
+
+func TestNewlineFrameSizeLimit(t *testing.T) {
+    _, err := NewlineDelimited{}.ReadFrame(bufio.NewReader(endless{}))
+    if !errors.Is(err, ErrFrameTooLarge) {
+        t.Errorf("ReadFrame of an endless line: %v, want ErrFrameTooLarge", err)
+    }
+
+    // escaping doubles every backslash, but the limit is on the frame itself
+    var line bytes.Buffer
+    frame := bytes.Repeat([]byte{'\\'}, MaxFrameSize)
+    if err := (NewlineDelimited{}).WriteFrame(&line, frame); err != nil {
+        t.Fatal(err)
+    }
+    got, err := NewlineDelimited{}.ReadFrame(bufio.NewReader(&line))
+    if err != nil || !bytes.Equal(got, frame) {
+        t.Errorf("a frame of exactly MaxFrameSize escaped bytes did not round trip: %v", err)
+    }
+}
@@ -0,0 +1,514 @@
+// adaptergen writes an object adapter that makes an adaptee type satisfy a
+// target interface, driven by a small mapping file:
+//
+//     # target method -> adaptee call
+//     import "strconv"
+//     InsertIntoLightningPort -> insertIntoUSBPort()
+//     SetName -> rename(strconv.Quote(name))
+//
+// Arguments of the adaptee call are Go expressions over the parameters of the
+// target method; unnamed parameters are called arg0, arg1, ... Packages the
+// copied signatures use are imported the way the target's file imports them;
+// import lines in the mapping file are only needed for the arguments
+//
+// Usage:
+//
+//     go run adaptergen/adaptergen.go -src Adapter.go -target Computer -adaptee Windows -map windows_adapter.map -o windows_adapter_gen.go
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "flag"
+    "fmt"
+    "go/ast"
+    "go/format"
+    "go/parser"
+    "go/token"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// Mapping is one line of the mapping file. Spread is set when the last
+// argument is passed on with ..., as in write(prefix, parts...)
+type Mapping struct {
+    Line   int
+    Method string
+    Call   string
+    Args   []string
+    Spread bool
+}
+
+type MappingFile struct {
+    Path     string
+    Imports  []string
+    Mappings map[string]Mapping
+}
+
+func ReadMappingFile(path string) (*MappingFile, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    mappingFile := &MappingFile{Path: path, Mappings: map[string]Mapping{}}
+    scanner := bufio.NewScanner(file)
+    for number := 1; scanner.Scan(); number++ {
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        if rest, ok := strings.CutPrefix(line, "import "); ok {
+            importPath, err := strconv.Unquote(strings.TrimSpace(rest))
+            if err != nil {
+                return nil, fmt.Errorf("%s:%d: import path must be quoted", path, number)
+            }
+            mappingFile.Imports = append(mappingFile.Imports, importPath)
+            continue
+        }
+        method, call, ok := strings.Cut(line, "->")
+        if !ok {
+            return nil, fmt.Errorf("%s:%d: expected \"TargetMethod -> adapteeMethod(args)\"", path, number)
+        }
+        mapping, err := parseMapping(strings.TrimSpace(method), strings.TrimSpace(call))
+        if err != nil {
+            return nil, fmt.Errorf("%s:%d: %v", path, number, err)
+        }
+        mapping.Line = number
+        if previous, ok := mappingFile.Mappings[mapping.Method]; ok {
+            return nil, fmt.Errorf("%s:%d: %s is already mapped on line %d", path, number, mapping.Method, previous.Line)
+        }
+        mappingFile.Mappings[mapping.Method] = mapping
+    }
+    return mappingFile, scanner.Err()
+}
+
+func parseMapping(method string, call string) (Mapping, error) {
+    if !token.IsIdentifier(method) {
+        return Mapping{}, fmt.Errorf("%q is not a method name", method)
+    }
+    expr, err := parser.ParseExpr(call)
+    if err != nil {
+        return Mapping{}, fmt.Errorf("cannot parse %q: %v", call, err)
+    }
+    callExpr, ok := expr.(*ast.CallExpr)
+    if !ok {
+        return Mapping{}, fmt.Errorf("%q is not a method call", call)
+    }
+    name, ok := callExpr.Fun.(*ast.Ident)
+    if !ok {
+        return Mapping{}, fmt.Errorf("%q must call an adaptee method by its bare name", call)
+    }
+    mapping := Mapping{Method: method, Call: name.Name, Spread: callExpr.Ellipsis.IsValid()}
+    for _, arg := range callExpr.Args {
+        mapping.Args = append(mapping.Args, render(arg))
+    }
+    return mapping, nil
+}
+
+// Method is a method of the target interface or the adaptee type.
+// ResultNames holds the names of named results, and Packages lists the
+// imported packages its signature refers to
+type Method struct {
+    Name        string
+    Params      []string
+    Types       []string
+    Variadic    bool
+    Results     string
+    ResultCount int
+    ResultNames []string
+    Packages    []string
+}
+
+func (m Method) Signature() string {
+    params := make([]string, len(m.Params))
+    for i := range m.Params {
+        params[i] = m.Params[i] + " " + m.Types[i]
+    }
+    return m.Name + "(" + strings.Join(params, ", ") + ")" + m.Results
+}
+
+func methodOf(name string, funcType *ast.FuncType) Method {
+    method := Method{Name: name}
+    for _, field := range funcType.Params.List {
+        typ := render(field.Type)
+        if _, ok := field.Type.(*ast.Ellipsis); ok {
+            method.Variadic = true
+        }
+        if len(field.Names) == 0 {
+            method.Params = append(method.Params, fmt.Sprintf("arg%d", len(method.Params)))
+            method.Types = append(method.Types, typ)
+        }
+        for _, paramName := range field.Names {
+            method.Params = append(method.Params, paramName.Name)
+            method.Types = append(method.Types, typ)
+        }
+    }
+    if funcType.Results != nil {
+        var results []string
+        for _, field := range funcType.Results.List {
+            typ := render(field.Type)
+            if len(field.Names) == 0 {
+                results = append(results, typ)
+            }
+            for _, resultName := range field.Names {
+                results = append(results, resultName.Name+" "+typ)
+                method.ResultNames = append(method.ResultNames, resultName.Name)
+            }
+        }
+        method.ResultCount = len(results)
+        if len(results) == 1 && len(funcType.Results.List[0].Names) == 0 {
+            method.Results = " " + results[0]
+        } else {
+            method.Results = " (" + strings.Join(results, ", ") + ")"
+        }
+    }
+    seen := map[string]bool{}
+    ast.Inspect(funcType, func(node ast.Node) bool {
+        if selector, ok := node.(*ast.SelectorExpr); ok {
+            if pkg, ok := selector.X.(*ast.Ident); ok && !seen[pkg.Name] {
+                seen[pkg.Name] = true
+                method.Packages = append(method.Packages, pkg.Name)
+            }
+        }
+        return true
+    })
+    return method
+}
+
+func render(node ast.Node) string {
+    var buffer bytes.Buffer
+    format.Node(&buffer, token.NewFileSet(), node)
+    return buffer.String()
+}
+
+// Package holds what the generator needs from the parsed sources.
+// interfaceImports maps each interface to the imports of its file, keyed by
+// the name the file uses for the package
+type Package struct {
+    Name             string
+    interfaces       map[string]*ast.InterfaceType
+    interfaceImports map[string]map[string]string
+    methods          map[string][]Method
+}
+
+func ParsePackage(paths []string) (*Package, error) {
+    pkg := &Package{
+        interfaces:       map[string]*ast.InterfaceType{},
+        interfaceImports: map[string]map[string]string{},
+        methods:          map[string][]Method{},
+    }
+    fileSet := token.NewFileSet()
+    for _, path := range paths {
+        file, err := parser.ParseFile(fileSet, path, nil, 0)
+        if err != nil {
+            return nil, err
+        }
+        if pkg.Name != "" && pkg.Name != file.Name.Name {
+            return nil, fmt.Errorf("%s is in package %s, not %s", path, file.Name.Name, pkg.Name)
+        }
+        pkg.Name = file.Name.Name
+        imports := fileImports(file)
+        for _, decl := range file.Decls {
+            switch decl := decl.(type) {
+            case *ast.GenDecl:
+                for _, spec := range decl.Specs {
+                    if typeSpec, ok := spec.(*ast.TypeSpec); ok {
+                        if iface, ok := typeSpec.Type.(*ast.InterfaceType); ok {
+                            pkg.interfaces[typeSpec.Name.Name] = iface
+                            pkg.interfaceImports[typeSpec.Name.Name] = imports
+                        }
+                    }
+                }
+            case *ast.FuncDecl:
+                if decl.Recv == nil || len(decl.Recv.List) == 0 {
+                    continue
+                }
+                receiver := decl.Recv.List[0].Type
+                if star, ok := receiver.(*ast.StarExpr); ok {
+                    receiver = star.X
+                }
+                if ident, ok := receiver.(*ast.Ident); ok {
+                    pkg.methods[ident.Name] = append(pkg.methods[ident.Name], methodOf(decl.Name.Name, decl.Type))
+                }
+            }
+        }
+    }
+    return pkg, nil
+}
+
+// fileImports returns the import specs of a file by local package name,
+// e.g. "io" -> `"io"` or "rand" -> `rand "math/rand/v2"`
+func fileImports(file *ast.File) map[string]string {
+    imports := map[string]string{}
+    for _, spec := range file.Imports {
+        importPath, _ := strconv.Unquote(spec.Path.Value)
+        name := importPath[strings.LastIndex(importPath, "/")+1:]
+        line := strconv.Quote(importPath)
+        if spec.Name != nil {
+            name = spec.Name.Name
+            line = name + " " + line
+        }
+        if name != "_" && name != "." {
+            imports[name] = line
+        }
+    }
+    return imports
+}
+
+func (p *Package) InterfaceMethods(name string) ([]Method, error) {
+    iface, ok := p.interfaces[name]
+    if !ok {
+        return nil, fmt.Errorf("interface %s not found", name)
+    }
+    var methods []Method
+    for _, field := range iface.Methods.List {
+        funcType, ok := field.Type.(*ast.FuncType)
+        if !ok {
+            return nil, fmt.Errorf("interface %s embeds %s; embedded interfaces are not supported", name, render(field.Type))
+        }
+        methods = append(methods, methodOf(field.Names[0].Name, funcType))
+    }
+    return methods, nil
+}
+
+func (p *Package) Methods(typeName string) (map[string]Method, error) {
+    methods, ok := p.methods[typeName]
+    if !ok {
+        return nil, fmt.Errorf("type %s has no methods", typeName)
+    }
+    byName := map[string]Method{}
+    for _, method := range methods {
+        byName[method.Name] = method
+    }
+    return byName, nil
+}
+
+// Generator checks a mapping file against both types and writes the adapter
+type Generator struct {
+    Package *Package
+    Target  string
+    Adaptee string
+    Name    string
+    Mapping *MappingFile
+}
+
+// Check reports every problem at once: unmapped target methods, mappings
+// for methods the target does not have, and calls the adaptee cannot take
+func (g *Generator) Check() ([]Method, []string) {
+    targetMethods, err := g.Package.InterfaceMethods(g.Target)
+    if err != nil {
+        return nil, []string{err.Error()}
+    }
+    adapteeMethods, err := g.Package.Methods(g.Adaptee)
+    if err != nil {
+        return nil, []string{err.Error()}
+    }
+
+    var problems []string
+    known := map[string]bool{}
+    for _, method := range targetMethods {
+        known[method.Name] = true
+        for _, pkg := range method.Packages {
+            if _, ok := g.Package.interfaceImports[g.Target][pkg]; !ok {
+                problems = append(problems, fmt.Sprintf("%s.%s refers to package %s, which the file declaring %s does not import",
+                    g.Target, method.Signature(), pkg, g.Target))
+            }
+        }
+        mapping, ok := g.Mapping.Mappings[method.Name]
+        if !ok {
+            problems = append(problems, fmt.Sprintf("%s: %s.%s is not mapped; add a line \"%s -> adapteeMethod(%s)\"",
+                g.Mapping.Path, g.Target, method.Signature(), method.Name, strings.Join(method.Params, ", ")))
+            continue
+        }
+        call, ok := adapteeMethods[mapping.Call]
+        if !ok {
+            problems = append(problems, fmt.Sprintf("%s:%d: %s has no method %s (it has %s)",
+                g.Mapping.Path, mapping.Line, g.Adaptee, mapping.Call, strings.Join(sortedNames(adapteeMethods), ", ")))
+            continue
+        }
+        if mapping.Spread && !call.Variadic {
+            problems = append(problems, fmt.Sprintf("%s:%d: %s.%s is not variadic, so its last argument cannot use ...",
+                g.Mapping.Path, mapping.Line, g.Adaptee, call.Signature()))
+            continue
+        }
+        // a spread slice fills the variadic parameter; otherwise it takes any number of items
+        argsOK := len(mapping.Args) == len(call.Params)
+        if call.Variadic && !mapping.Spread {
+            argsOK = len(mapping.Args) >= len(call.Params)-1
+        }
+        if !argsOK {
+            problems = append(problems, fmt.Sprintf("%s:%d: %s.%s takes %d argument(s), mapping passes %d",
+                g.Mapping.Path, mapping.Line, g.Adaptee, call.Signature(), len(call.Params), len(mapping.Args)))
+        }
+        // the adapter returns the call's results as they are; a target
+        // without results just drops them
+        if method.ResultCount > 0 && method.ResultCount != call.ResultCount {
+            problems = append(problems, fmt.Sprintf("%s:%d: %s.%s returns %d value(s), but %s.%s returns %d",
+                g.Mapping.Path, mapping.Line, g.Target, method.Signature(), method.ResultCount,
+                g.Adaptee, call.Signature(), call.ResultCount))
+        }
+    }
+    for _, mapping := range g.Mapping.Mappings {
+        if !known[mapping.Method] {
+            problems = append(problems, fmt.Sprintf("%s:%d: %s has no method %s", g.Mapping.Path, mapping.Line, g.Target, mapping.Method))
+        }
+    }
+    sort.Strings(problems)
+    return targetMethods, problems
+}
+
+func sortedNames(methods map[string]Method) []string {
+    names := make([]string, 0, len(methods))
+    for name := range methods {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// imports are the packages the copied signatures use, imported the way the
+// target's file imports them, plus the ones the mapping file asks for
+func (g *Generator) imports(methods []Method) []string {
+    seen := map[string]bool{}
+    var imports []string
+    add := func(line string) {
+        if !seen[line] {
+            seen[line] = true
+            imports = append(imports, line)
+        }
+    }
+    for _, method := range methods {
+        for _, pkg := range method.Packages {
+            add(g.Package.interfaceImports[g.Target][pkg])
+        }
+    }
+    for _, importPath := range g.Mapping.Imports {
+        add(strconv.Quote(importPath))
+    }
+    sort.Strings(imports)
+    return imports
+}
+
+// receiverName picks a receiver that no parameter, named result, package
+// or name in a mapping argument shadows or is shadowed by
+func (g *Generator) receiverName(methods []Method) string {
+    taken := map[string]bool{}
+    for _, method := range methods {
+        for _, names := range [][]string{method.Params, method.ResultNames, method.Packages} {
+            for _, name := range names {
+                taken[name] = true
+            }
+        }
+        for _, arg := range g.Mapping.Mappings[method.Name].Args {
+            if expr, err := parser.ParseExpr(arg); err == nil {
+                ast.Inspect(expr, func(node ast.Node) bool {
+                    if ident, ok := node.(*ast.Ident); ok {
+                        taken[ident.Name] = true
+                    }
+                    return true
+                })
+            }
+        }
+    }
+    for _, name := range []string{"a", "adapter"} {
+        if !taken[name] {
+            return name
+        }
+    }
+    for i := 1; ; i++ {
+        if name := fmt.Sprintf("adapter%d", i); !taken[name] {
+            return name
+        }
+    }
+}
+
+func (g *Generator) Generate(methods []Method) ([]byte, error) {
+    var source bytes.Buffer
+    fmt.Fprintf(&source, "// Code generated by adaptergen from %s; DO NOT EDIT.\n\n", filepath.Base(g.Mapping.Path))
+    fmt.Fprintf(&source, "package %s\n\n", g.Package.Name)
+    if imports := g.imports(methods); len(imports) > 0 {
+        source.WriteString("import (\n")
+        for _, line := range imports {
+            source.WriteString(line + "\n")
+        }
+        source.WriteString(")\n\n")
+    }
+    fmt.Fprintf(&source, "// %s adapts %s to %s\n", g.Name, g.Adaptee, g.Target)
+    fmt.Fprintf(&source, "type %s struct {\nadaptee *%s\n}\n\n", g.Name, g.Adaptee)
+    fmt.Fprintf(&source, "var _ %s = (*%s)(nil)\n\n", g.Target, g.Name)
+    fmt.Fprintf(&source, "func New%s(adaptee *%s) *%s {\nreturn &%s{adaptee: adaptee}\n}\n", g.Name, g.Adaptee, g.Name, g.Name)
+    receiver := g.receiverName(methods)
+    for _, method := range methods {
+        mapping := g.Mapping.Mappings[method.Name]
+        args := strings.Join(mapping.Args, ", ")
+        if mapping.Spread {
+            args += "..."
+        }
+        call := fmt.Sprintf("%s.adaptee.%s(%s)", receiver, mapping.Call, args)
+        if method.ResultCount > 0 {
+            call = "return " + call
+        }
+        fmt.Fprintf(&source, "\nfunc (%s *%s) %s {\n%s\n}\n", receiver, g.Name, method.Signature(), call)
+    }
+    formatted, err := format.Source(source.Bytes())
+    if err != nil {
+        return nil, fmt.Errorf("generated adapter does not parse: %v\n%s", err, source.Bytes())
+    }
+    return formatted, nil
+}
+
+func main() {
+    src := flag.String("src", "", "comma-separated Go files declaring the target and the adaptee")
+    target := flag.String("target", "", "interface the adapter implements")
+    adaptee := flag.String("adaptee", "", "type the adapter wraps")
+    mapPath := flag.String("map", "", "mapping file")
+    name := flag.String("name", "", "adapter type name (default <adaptee><target>Adapter)")
+    out := flag.String("o", "", "output file (default stdout)")
+    flag.Parse()
+
+    if *src == "" || *target == "" || *adaptee == "" || *mapPath == "" {
+        fmt.Fprintln(os.Stderr, "adaptergen: -src, -target, -adaptee and -map are required")
+        flag.Usage()
+        os.Exit(2)
+    }
+    if *name == "" {
+        *name = *adaptee + *target + "Adapter"
+    }
+
+    pkg, err := ParsePackage(strings.Split(*src, ","))
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "adaptergen:", err)
+        os.Exit(1)
+    }
+    mappingFile, err := ReadMappingFile(*mapPath)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "adaptergen:", err)
+        os.Exit(1)
+    }
+    generator := &Generator{Package: pkg, Target: *target, Adaptee: *adaptee, Name: *name, Mapping: mappingFile}
+    methods, problems := generator.Check()
+    if len(problems) > 0 {
+        for _, problem := range problems {
+            fmt.Fprintln(os.Stderr, "adaptergen:", problem)
+        }
+        os.Exit(1)
+    }
+    generated, err := generator.Generate(methods)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "adaptergen:", err)
+        os.Exit(1)
+    }
+    if *out == "" {
+        os.Stdout.Write(generated)
+        return
+    }
+    if err := os.WriteFile(*out, generated, 0o644); err != nil {
+        fmt.Fprintln(os.Stderr, "adaptergen:", err)
+        os.Exit(1)
+    }
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+    "go/ast"
+    "go/importer"
+    "go/parser"
+    "go/token"
+    "go/types"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+// generate runs the generator over a source file and a mapping file written
+// to a temporary directory
+func generate(t *testing.T, source string, mapping string) (string, []string) {
+    t.Helper()
+    dir := t.TempDir()
+    srcPath := filepath.Join(dir, "types.go")
+    mapPath := filepath.Join(dir, "adapter.map")
+    if err := os.WriteFile(srcPath, []byte(source), 0o644); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.WriteFile(mapPath, []byte(mapping), 0o644); err != nil {
+        t.Fatal(err)
+    }
+    pkg, err := ParsePackage([]string{srcPath})
+    if err != nil {
+        t.Fatal(err)
+    }
+    mappingFile, err := ReadMappingFile(mapPath)
+    if err != nil {
+        t.Fatal(err)
+    }
+    generator := &Generator{Package: pkg, Target: "Target", Adaptee: "Adaptee", Name: "Adapter", Mapping: mappingFile}
+    methods, problems := generator.Check()
+    if len(problems) > 0 {
+        return "", problems
+    }
+    generated, err := generator.Generate(methods)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return string(generated), nil
+}
+
+// typeCheck compiles the generated adapter together with the source it adapts
+func typeCheck(t *testing.T, source string, generated string) {
+    t.Helper()
+    fileSet := token.NewFileSet()
+    var files []*ast.File
+    for name, text := range map[string]string{"types.go": source, "adapter_gen.go": generated} {
+        file, err := parser.ParseFile(fileSet, name, text, 0)
+        if err != nil {
+            t.Fatal(err)
+        }
+        files = append(files, file)
+    }
+    config := types.Config{Importer: importer.ForCompiler(fileSet, "source", nil)}
+    if _, err := config.Check("main", fileSet, files, nil); err != nil {
+        t.Errorf("generated adapter does not compile: %v\n%s", err, generated)
+    }
+}
+
+func hasProblem(problems []string, substring string) bool {
+    for _, problem := range problems {
+        if strings.Contains(problem, substring) {
+            return true
+        }
+    }
+    return false
+}
+
+func TestUnmappedMethod(t *testing.T) {
+    source := `package main
+
+type Target interface {
+    Start()
+    Stop()
+}
+
+type Adaptee struct{}
+
+func (a *Adaptee) begin() {}
+`
+    _, problems := generate(t, source, "Start -> begin()\nRestart -> begin()\n")
+    if !hasProblem(problems, "Target.Stop() is not mapped") {
+        t.Errorf("problems %q do not report the unmapped Stop", problems)
+    }
+    if !hasProblem(problems, "Target has no method Restart") {
+        t.Errorf("problems %q do not report the mapping for a missing method", problems)
+    }
+}
+
+func TestSpreadArguments(t *testing.T) {
+    source := `package main
+
+type Target interface {
+    Log(prefix string, parts ...string) int
+    Count(n int) int
+}
+
+type Adaptee struct{}
+
+func (a *Adaptee) write(prefix string, parts ...string) int { return len(parts) }
+func (a *Adaptee) add(n int) int { return n }
+`
+    generated, problems := generate(t, source, "Log -> write(prefix, parts...)\nCount -> add(n)\n")
+    if len(problems) > 0 {
+        t.Fatal(problems)
+    }
+    if !strings.Contains(generated, "write(prefix, parts...)") {
+        t.Errorf("the spread argument lost its ...:\n%s", generated)
+    }
+    typeCheck(t, source, generated)
+
+    _, problems = generate(t, source, "Log -> write(prefix, parts...)\nCount -> add(n...)\n")
+    if !hasProblem(problems, "is not variadic") {
+        t.Errorf("problems %q do not report ... on a non-variadic call", problems)
+    }
+}
+
+func TestSignatureImports(t *testing.T) {
+    source := `package main
+
+import (
+    "io"
+    rand "math/rand/v2"
+)
+
+type Target interface {
+    Read(r io.Reader, source *rand.Rand) (int, error)
+}
+
+type Adaptee struct{}
+
+func (a *Adaptee) consume(r io.Reader) (int, error) { return 0, nil }
+`
+    generated, problems := generate(t, source, "import \"bufio\"\nRead -> consume(bufio.NewReader(r))\n")
+    if len(problems) > 0 {
+        t.Fatal(problems)
+    }
+    for _, line := range []string{`"bufio"`, `"io"`, `rand "math/rand/v2"`} {
+        if !strings.Contains(generated, line) {
+            t.Errorf("generated adapter does not import %s:\n%s", line, generated)
+        }
+    }
+    typeCheck(t, source, generated)
+}
+
+func TestReceiverDoesNotClash(t *testing.T) {
+    source := `package main
+
+type Target interface {
+    Set(a int, adapter string) (adapter1 bool)
+}
+
+type Adaptee struct{}
+
+func (a *Adaptee) store(n int, name string) bool { return n > 0 && name != "" }
+`
+    generated, problems := generate(t, source, "Set -> store(a, adapter)\n")
+    if len(problems) > 0 {
+        t.Fatal(problems)
+    }
+    typeCheck(t, source, generated)
+}
+
+func TestResultCountMismatch(t *testing.T) {
+    source := `package main
+
+type Target interface {
+    Size() int
+    Reset()
+}
+
+type Adaptee struct{}
+
+func (a *Adaptee) measure() {}
+func (a *Adaptee) clear() bool { return true }
+`
+    _, problems := generate(t, source, "Size -> measure()\nReset -> clear()\n")
+    if len(problems) != 1 || !hasProblem(problems, "Target.Size() int returns 1 value(s), but Adaptee.measure() returns 0") {
+        t.Errorf("problems = %q, want only the Size mismatch; Reset may drop clear's result", problems)
+    }
+}
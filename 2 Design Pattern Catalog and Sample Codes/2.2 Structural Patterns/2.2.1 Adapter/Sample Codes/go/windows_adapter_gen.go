@@ -0,0 +1,18 @@
+// Code generated by adaptergen from windows_adapter.map; DO NOT EDIT.
+
+package main
+
+// WindowsComputerAdapter adapts Windows to Computer
+type WindowsComputerAdapter struct {
+	adaptee *Windows
+}
+
+var _ Computer = (*WindowsComputerAdapter)(nil)
+
+func NewWindowsComputerAdapter(adaptee *Windows) *WindowsComputerAdapter {
+	return &WindowsComputerAdapter{adaptee: adaptee}
+}
+
+func (a *WindowsComputerAdapter) InsertIntoLightningPort() {
+	a.adaptee.insertIntoUSBPort()
+}